@@ -0,0 +1,337 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Minimum time between two progress updates of a batch
+const batchUpdateInterval = time.Second
+
+// Videos added together, followed and controlled as a whole
+type Batch struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Its videos are not dispatched
+	Paused    bool      `json:"paused"`
+	CreatedAt time.Time `json:"createdAt"`
+	// When its first video started
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+}
+
+type BatchCounts struct {
+	Total     int `json:"total"`
+	Queued    int `json:"queued"`
+	Running   int `json:"running"`
+	Done      int `json:"done"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+	Cancelled int `json:"cancelled"`
+}
+
+type BatchInfo struct {
+	Batch
+	BatchCounts
+	// Finished videos count as 100, the queued ones as 0
+	Progress float64 `json:"progress"`
+	// Seconds left at the speed since the batch started, not set
+	// when it can't be estimated
+	ETA *float64 `json:"eta,omitempty"`
+}
+
+type CreateBatchRequest struct {
+	Name string `json:"name" form:"name" binding:"required"`
+}
+
+// Last update sent for each batch, the progress updates are throttled
+var batchUpdatesLock sync.Mutex
+var batchUpdatesSent = map[int64]time.Time{}
+
+func (p *PoolWorker) PausedBatches() map[int64]bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	paused := map[int64]bool{}
+	for id := range p.pausedBatches {
+		paused[id] = true
+	}
+
+	return paused
+}
+
+// Stop dispatching the videos of the batch, the running ones are
+// put back in the queue when suspend is set
+func (p *PoolWorker) SetBatchPaused(id int64, paused bool, suspend bool) error {
+	err := sqlite.SetBatchPaused(id, paused)
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	if paused {
+		p.pausedBatches[id] = true
+	} else {
+		delete(p.pausedBatches, id)
+	}
+	p.Unlock()
+
+	p.signalChanged()
+	if paused && suspend {
+		for _, worker := range p.Workers() {
+			if info := worker.GetInfo(); info.Video != nil && info.Video.BatchID == id {
+				worker.SuspendCurrent()
+			}
+		}
+	}
+
+	return nil
+}
+
+// Progress of the running videos of the batch by video id
+func (p *PoolWorker) batchProgress(id int64) map[int64]float64 {
+	progress := map[int64]float64{}
+	for _, info := range p.GetWorkerInfos() {
+		if info.Video != nil && info.Video.BatchID == id && info.JobProgress >= progress[info.Video.ID] {
+			progress[info.Video.ID] = info.JobProgress
+		}
+	}
+
+	p.chunkedJobsLock.Lock()
+	defer p.chunkedJobsLock.Unlock()
+
+	// Also running between its chunks
+	for videoID, job := range p.chunkedJobs {
+		if job.video.BatchID == id {
+			progress[videoID] = job.Progress()
+		}
+	}
+
+	return progress
+}
+
+func GetBatchInfo(batch Batch) (BatchInfo, error) {
+	counts, err := sqlite.GetBatchCounts(batch.ID)
+	if err != nil {
+		return BatchInfo{}, err
+	}
+
+	info := BatchInfo{Batch: batch, BatchCounts: counts}
+	finished := counts.Done + counts.Skipped + counts.Failed + counts.Cancelled
+	progress := poolWorker.batchProgress(batch.ID)
+	info.Running = len(progress)
+	info.Queued = max(counts.Total-finished-info.Running, 0)
+	if counts.Total == 0 {
+		return info, nil
+	}
+
+	total := float64(finished) * 100
+	for _, videoProgress := range progress {
+		total += videoProgress
+	}
+
+	info.Progress = total / float64(counts.Total)
+	if batch.StartedAt != nil && !batch.Paused && info.Progress > 0 && info.Progress < 100 {
+		elapsed := time.Since(*batch.StartedAt).Seconds()
+		eta := elapsed * (100 - info.Progress) / info.Progress
+		info.ETA = &eta
+	}
+
+	return info, nil
+}
+
+// Send the info of the batch, skipped when an update was sent less
+// than batchUpdateInterval ago unless forced
+func sendBatchUpdate(id int64, force bool) {
+	if id == 0 {
+		return
+	}
+
+	batchUpdatesLock.Lock()
+	if !force && time.Since(batchUpdatesSent[id]) < batchUpdateInterval {
+		batchUpdatesLock.Unlock()
+		return
+	}
+
+	batchUpdatesSent[id] = time.Now()
+	batchUpdatesLock.Unlock()
+
+	batch, ok, err := sqlite.GetBatchByID(id)
+	if err != nil || !ok {
+		log.WithField("batchId", id).Error("Failed to get the batch: ", err)
+		return
+	}
+
+	info, err := GetBatchInfo(batch)
+	if err != nil {
+		log.WithField("batchId", id).Error("Failed to get the batch info: ", err)
+		return
+	}
+
+	packet := WsBatchUpdate{
+		WsBaseMessage: WsBaseMessage{
+			Type: "batch_update",
+		},
+		BatchInfo: info,
+	}
+
+	hub.BroadcastMessage(packet)
+}
+
+// The batch of the id param, the response is sent when it fails
+func batchFromParam(c *gin.Context) (Batch, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(400, err.Error())
+		return Batch{}, false
+	}
+
+	batch, ok, err := sqlite.GetBatchByID(id)
+	if err != nil {
+		c.String(400, err.Error())
+		return Batch{}, false
+	}
+
+	if !ok {
+		c.String(404, "batch not found")
+		return Batch{}, false
+	}
+
+	return batch, true
+}
+
+func listBatches(c *gin.Context) {
+	batches, err := sqlite.GetBatches()
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	infos := []BatchInfo{}
+	for _, batch := range batches {
+		info, err := GetBatchInfo(batch)
+		if err != nil {
+			c.String(400, err.Error())
+			return
+		}
+
+		infos = append(infos, info)
+	}
+
+	c.JSON(200, infos)
+}
+
+func createBatch(c *gin.Context) {
+	var request CreateBatchRequest
+	if err := c.ShouldBind(&request); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	batch := Batch{Name: request.Name, CreatedAt: time.Now()}
+	if err := sqlite.InsertBatch(&batch); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	log.WithFields(StructFields(batch)).Info("Created batch")
+	c.JSON(200, batch)
+}
+
+func getBatch(c *gin.Context) {
+	batch, ok := batchFromParam(c)
+	if !ok {
+		return
+	}
+
+	info, err := GetBatchInfo(batch)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	c.JSON(200, info)
+}
+
+func pauseBatch(c *gin.Context) {
+	setBatchPaused(c, true)
+}
+
+func resumeBatch(c *gin.Context) {
+	setBatchPaused(c, false)
+}
+
+func setBatchPaused(c *gin.Context, paused bool) {
+	batch, ok := batchFromParam(c)
+	if !ok {
+		return
+	}
+
+	// Pausing doesn't stop the running videos unless asked
+	suspend := c.Query("suspend") == "true"
+	if err := poolWorker.SetBatchPaused(batch.ID, paused, suspend); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	log.WithField("batchId", batch.ID).WithField("paused", paused).Info("Sucessfully changed batch paused state")
+	sendBatchUpdate(batch.ID, true)
+	getBatch(c)
+}
+
+// Cancel the queued and running videos of the batch
+func cancelBatch(c *gin.Context) {
+	batch, ok := batchFromParam(c)
+	if !ok {
+		return
+	}
+
+	cancelled := map[int64]bool{}
+	videos := append(gQueue.GetVideos(), poolWorker.RunningVideos()...)
+	for _, video := range videos {
+		if video.BatchID != batch.ID || cancelled[video.ID] {
+			continue
+		}
+
+		// Chunks share the id of their video
+		cancelled[video.ID] = true
+		if err := cancelVideoByID(video.ID); err != nil {
+			log.WithField("id", video.ID).Warn("Failed to cancel the video of the batch: ", err)
+		}
+	}
+
+	log.WithField("batchId", batch.ID).WithField("count", len(cancelled)).Info("Canceled batch")
+	getBatch(c)
+}
+
+// Put the failed videos of the batch back in the queue
+func retryBatch(c *gin.Context) {
+	batch, ok := batchFromParam(c)
+	if !ok {
+		return
+	}
+
+	failedVideos, err := sqlite.GetFailedVideos(false)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	matching := []FailedVideo{}
+	for _, failed := range failedVideos {
+		if failed.Video.BatchID == batch.ID {
+			matching = append(matching, failed)
+		}
+	}
+
+	log.WithField("batchId", batch.ID).WithField("count", len(matching)).Debug("Retrying batch")
+	videos, _, err := retryFailed(matching)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	c.JSON(200, videos)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultOutputTemplate  = "{dir}/{stem}.{fps}fps{ext}"
+	defaultOutRootTemplate = "{outRoot}/{relpath}"
+)
+
+// Extensions taken from a directory when no include glob is given
+var videoExtensions = []string{".mkv", ".mp4", ".avi", ".mov", ".webm", ".m4v", ".ts", ".wmv", ".flv"}
+
+// Files taken from a folder
+type FileFilter struct {
+	// Globs matched on the file name or on the path relative to the folder
+	Include []string `json:"include" yaml:"include"`
+	Exclude []string `json:"exclude" yaml:"exclude"`
+	// File size limits in bytes, 0 is no limit
+	MinSize int64 `json:"minSize" yaml:"minSize"`
+	MaxSize int64 `json:"maxSize" yaml:"maxSize"`
+}
+
+type BulkEnqueueRequest struct {
+	// Videos to add, an empty or templated outPath uses the template
+	Jobs []Video `json:"jobs"`
+	// Or every video in the directory matching the filters
+	Dir       string `json:"dir"`
+	Recursive bool   `json:"recursive"`
+	FileFilter
+	// Output path template, {dir}/{stem}.{fps}fps{ext} by default
+	// or {outRoot}/{relpath} when outRoot is set
+	OutputTemplate string `json:"outTemplate"`
+	OutRoot        string `json:"outRoot"`
+	// Settings of the videos found in dir
+	Mode     string        `json:"mode"`
+	Priority int           `json:"priority"`
+	Settings VideoSettings `json:"settings"`
+	Profile  string        `json:"profile"`
+	// Batch of the videos found in dir and of the jobs without one
+	BatchID int64 `json:"batchId"`
+	// Added to the labels of every video
+	Labels []string `json:"labels"`
+	// Return the planned videos without adding them
+	DryRun bool `json:"dryRun"`
+}
+
+type BulkEnqueueResult struct {
+	Video     Video  `json:"video"`
+	Duplicate bool   `json:"duplicate"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Replace the template variables for the video at path, relPath
+// being its path relative to the bulk directory
+func expandOutputTemplate(template string, path string, relPath string, outRoot string, fps float64) string {
+	ext := filepath.Ext(path)
+	replacer := strings.NewReplacer(
+		"{dir}", filepath.Dir(path),
+		"{name}", filepath.Base(path),
+		"{stem}", strings.TrimSuffix(filepath.Base(path), ext),
+		"{ext}", ext,
+		"{fps}", strconv.FormatFloat(fps, 'f', -1, 64),
+		"{outRoot}", outRoot,
+		"{relpath}", relPath,
+		"{reldir}", filepath.Dir(relPath),
+	)
+
+	return filepath.Clean(replacer.Replace(template))
+}
+
+// The template or the default one
+func outputTemplateOrDefault(template string, outRoot string) string {
+	if template != "" {
+		return template
+	}
+
+	if outRoot != "" {
+		return defaultOutRootTemplate
+	}
+
+	return defaultOutputTemplate
+}
+
+func matchAnyGlob(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, filepath.Base(relPath)); ok {
+			return true
+		}
+
+		if ok, _ := filepath.Match(pattern, filepath.ToSlash(relPath)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isVideoFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, videoExt := range videoExtensions {
+		if ext == videoExt {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *FileFilter) MatchName(relPath string) bool {
+	if len(f.Include) > 0 && !matchAnyGlob(f.Include, relPath) {
+		return false
+	}
+
+	if len(f.Include) == 0 && !isVideoFile(relPath) {
+		return false
+	}
+
+	return !matchAnyGlob(f.Exclude, relPath)
+}
+
+func (f *FileFilter) MatchSize(size int64) bool {
+	return size >= f.MinSize && (f.MaxSize == 0 || size <= f.MaxSize)
+}
+
+// Output path of the video from the template, {fps} being the target
+// fps of the video once its profile is selected
+func templatedOutputPath(video *Video, template string, relPath string, outRoot string, config *Config) string {
+	// An unknown profile is reported when the video is added
+	_ = applyProfile(video, config)
+	fps := video.ResolveSettings(config).TargetFPS
+	return expandOutputTemplate(template, video.Path, relPath, outRoot, fps)
+}
+
+// Find the videos of the directory matching the filters, returns their relative paths
+func (r *BulkEnqueueRequest) findVideos() ([]string, error) {
+	found := []string{}
+	err := filepath.WalkDir(r.Dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if path != r.Dir && !r.Recursive {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		relPath, err := filepath.Rel(r.Dir, path)
+		if err != nil {
+			return err
+		}
+
+		if !r.MatchName(relPath) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if !r.MatchSize(info.Size()) {
+			return nil
+		}
+
+		found = append(found, relPath)
+		return nil
+	})
+
+	return found, err
+}
+
+// The videos to add, with their output paths from the template
+func (r *BulkEnqueueRequest) plan(config *Config) ([]Video, error) {
+	if len(r.Jobs) == 0 && r.Dir == "" {
+		return nil, errors.New("jobs or dir is required")
+	}
+
+	template := outputTemplateOrDefault(r.OutputTemplate, r.OutRoot)
+	videos := []Video{}
+	for _, job := range r.Jobs {
+		jobTemplate := job.OutputPath
+		if jobTemplate == "" {
+			jobTemplate = template
+		}
+
+		if job.BatchID == 0 {
+			job.BatchID = r.BatchID
+		}
+
+		job.Labels = append(job.Labels, r.Labels...)
+
+		job.OutputPath = templatedOutputPath(&job, jobTemplate, filepath.Base(job.Path), r.OutRoot, config)
+		videos = append(videos, job)
+	}
+
+	if r.Dir != "" {
+		relPaths, err := r.findVideos()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, relPath := range relPaths {
+			video := Video{
+				Path:     filepath.Join(r.Dir, relPath),
+				Mode:     r.Mode,
+				Priority: r.Priority,
+				Settings: r.Settings,
+				Profile:  r.Profile,
+				BatchID:  r.BatchID,
+				Labels:   r.Labels,
+			}
+
+			video.OutputPath = templatedOutputPath(&video, template, relPath, r.OutRoot, config)
+			videos = append(videos, video)
+		}
+	}
+
+	return videos, nil
+}
+
+func addVideosToQueue(c *gin.Context) {
+	var request BulkEnqueueRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	videos, err := request.plan(poolWorker.config)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	log.WithField("count", len(videos)).WithField("dryRun", request.DryRun).Debug("Adding videos to queue")
+	results := []BulkEnqueueResult{}
+	for _, planned := range videos {
+		var video Video
+		var duplicate bool
+		var err error
+		if request.DryRun {
+			video, duplicate, err = PlanVideo(planned)
+		} else {
+			video, duplicate, err = EnqueueVideo(planned, "")
+		}
+
+		result := BulkEnqueueResult{Video: video, Duplicate: duplicate}
+		if err != nil {
+			result.Video = planned
+			result.Error = err.Error()
+		}
+
+		results = append(results, result)
+	}
+
+	c.JSON(200, results)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A fully flushed part of the output, the video
+// can be resumed from the frame after LastFrame
+type VideoCheckpoint struct {
+	PartPath  string `json:"partPath"`
+	LastFrame int64  `json:"lastFrame"`
+}
+
+func checkpointPartPath(outputPath string, index int) string {
+	ext := filepath.Ext(outputPath)
+	return fmt.Sprintf("%s.ckpt%03d%s", strings.TrimSuffix(outputPath, ext), index, ext)
+}
+
+// Remove the checkpoints of the video and their parts
+func ClearCheckpoints(video *Video) error {
+	checkpoints, err := sqlite.GetCheckpoints(video)
+	if err != nil {
+		return err
+	}
+
+	for _, checkpoint := range checkpoints {
+		_ = os.Remove(checkpoint.PartPath)
+	}
+
+	return sqlite.DeleteCheckpoints(video)
+}
+
+// Interpolate the video in parts, saving a checkpoint after each part
+// so it can be resumed after a restart. The parts are then concatenated
+// into the output path
+func (w *Worker) interpolateWithCheckpoints(video *Video, videoInfo *VideoInfo,
+	outputPath string, progressChan chan<- float64) (string, error) {
+	checkpoints, err := sqlite.GetCheckpoints(video)
+	if err != nil {
+		return "", err
+	}
+
+	parts := []string{}
+	startFrame := int64(0)
+	for _, checkpoint := range checkpoints {
+		partExist, err := PathExist(checkpoint.PartPath)
+		if err != nil {
+			return "", err
+		}
+
+		if !partExist {
+			w.logger.WithField("part", checkpoint.PartPath).
+				Warn("Checkpoint part is missing, starting from the beginning")
+			if err := ClearCheckpoints(video); err != nil {
+				return "", err
+			}
+
+			parts = []string{}
+			startFrame = 0
+			break
+		}
+
+		parts = append(parts, checkpoint.PartPath)
+		startFrame = checkpoint.LastFrame + 1
+	}
+
+	settings := w.settings(video)
+	partFrames := int64(math.Round(w.poolWorker.config.Checkpoint.Interval * settings.TargetFPS))
+	firstPart := len(parts)
+	err = w.interpolate(&Interpolation{
+		VideoInfo:     videoInfo,
+		OutputPath:    outputPath,
+		StartFrame:    startFrame,
+		TargetFPS:     settings.TargetFPS,
+		ModelPath:     settings.ModelPath,
+		Rife:          settings.Rife,
+		FFmpegOptions: settings.FFmpegOptions,
+		PartFrames:    max(partFrames, 1),
+		PartPath: func(index int) string {
+			return checkpointPartPath(outputPath, firstPart+index)
+		},
+		OnPartDone: func(path string, lastFrame int64) error {
+			w.logger.WithField("part", path).Debug("Saving checkpoint at frame: ", lastFrame)
+			parts = append(parts, path)
+			return sqlite.InsertCheckpoint(video, VideoCheckpoint{
+				PartPath:  path,
+				LastFrame: lastFrame,
+			})
+		},
+	}, progressChan)
+	if err != nil {
+		return "", err
+	}
+
+	w.updateStep("Concatenating parts")
+	tags := NewInterpolarrTags(video, &settings, videoInfo)
+	output, err := ConcatVideoParts(w.ctx(), parts, video.Path, outputPath, tags)
+	if err != nil {
+		return output, err
+	}
+
+	if err := ClearCheckpoints(video); err != nil {
+		w.logger.Error("Failed to clear checkpoints: ", err)
+	}
+
+	return "", nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type VideoChunk struct {
+	Index    int     `json:"index"`
+	Count    int     `json:"count"`
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+	Path     string  `json:"-"`
+	Retries  int     `json:"retries"`
+}
+
+// A video that was split into multiple chunks, each chunk
+// is processed by a worker as a sub job of the parent video
+type ChunkedJob struct {
+	video      Video
+	videoInfo  VideoInfo
+	outputPath string
+	useTmpFile bool
+	chunks     []*VideoChunk
+	progress   []float64
+	done       []bool
+	remaining  int
+	sync.Mutex
+}
+
+// Split the video at keyframes into count chunks of about the same duration
+func planChunks(keyframes []float64, duration float64, count int) []VideoSegment {
+	boundaries := []float64{0}
+	for i := 1; i < count; i++ {
+		target := duration * float64(i) / float64(count)
+
+		// Take the closest keyframe to the target
+		best := -1.0
+		for _, keyframe := range keyframes {
+			if keyframe <= boundaries[len(boundaries)-1] || keyframe >= duration {
+				continue
+			}
+
+			if best < 0 || math.Abs(keyframe-target) < math.Abs(best-target) {
+				best = keyframe
+			}
+		}
+
+		if best < 0 {
+			break
+		}
+
+		boundaries = append(boundaries, best)
+	}
+
+	segments := make([]VideoSegment, len(boundaries))
+	for i, start := range boundaries {
+		end := duration
+		if i+1 < len(boundaries) {
+			end = boundaries[i+1]
+		}
+
+		segments[i] = VideoSegment{Start: start, Duration: end - start}
+	}
+
+	return segments
+}
+
+func chunkPartPath(outputPath string, index int) string {
+	ext := filepath.Ext(outputPath)
+	return fmt.Sprintf("%s.part%03d%s", strings.TrimSuffix(outputPath, ext), index, ext)
+}
+
+func NewChunkedJob(video Video, videoInfo VideoInfo, outputPath string,
+	useTmpFile bool, segments []VideoSegment) *ChunkedJob {
+	chunks := make([]*VideoChunk, len(segments))
+	for i, segment := range segments {
+		chunks[i] = &VideoChunk{
+			Index:    i,
+			Count:    len(segments),
+			Start:    segment.Start,
+			Duration: segment.Duration,
+			Path:     chunkPartPath(outputPath, i),
+		}
+	}
+
+	return &ChunkedJob{
+		video:      video,
+		videoInfo:  videoInfo,
+		outputPath: outputPath,
+		useTmpFile: useTmpFile,
+		chunks:     chunks,
+		progress:   make([]float64, len(chunks)),
+		done:       make([]bool, len(chunks)),
+		remaining:  len(chunks),
+	}
+}
+
+// Sub jobs to be sent to the workers
+func (j *ChunkedJob) Videos() []Video {
+	videos := make([]Video, len(j.chunks))
+	for i, chunk := range j.chunks {
+		video := j.video
+		video.Chunk = chunk
+		videos[i] = video
+	}
+
+	return videos
+}
+
+// The info of the chunk, frame count only being the chunk frame count
+func (j *ChunkedJob) ChunkInfo(chunk *VideoChunk) VideoInfo {
+	return j.videoInfo.SegmentInfo(VideoSegment{Start: chunk.Start, Duration: chunk.Duration})
+}
+
+// Update the progress of a chunk and return the progress of
+// the whole job, which is the sum of the chunks progress
+func (j *ChunkedJob) SetProgress(chunk *VideoChunk, progress float64) float64 {
+	j.Lock()
+	defer j.Unlock()
+
+	j.progress[chunk.Index] = progress
+	return j.progressInternal()
+}
+
+func (j *ChunkedJob) Progress() float64 {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.progressInternal()
+}
+
+func (j *ChunkedJob) progressInternal() float64 {
+	total := 0.0
+	for i, chunk := range j.chunks {
+		if j.videoInfo.Duration > 0 {
+			total += j.progress[i] * chunk.Duration / j.videoInfo.Duration
+		} else {
+			total += j.progress[i] / float64(len(j.chunks))
+		}
+	}
+
+	return total
+}
+
+// Mark a chunk as done, returns true when every chunk is done
+func (j *ChunkedJob) MarkChunkDone(chunk *VideoChunk) bool {
+	j.Lock()
+	defer j.Unlock()
+
+	if !j.done[chunk.Index] {
+		j.done[chunk.Index] = true
+		j.progress[chunk.Index] = 100
+		j.remaining--
+	}
+
+	return j.remaining == 0
+}
+
+func (j *ChunkedJob) PartPaths() []string {
+	paths := make([]string, len(j.chunks))
+	for i, chunk := range j.chunks {
+		paths[i] = chunk.Path
+	}
+
+	return paths
+}
+
+func (j *ChunkedJob) RemoveParts() {
+	for _, chunk := range j.chunks {
+		_ = os.Remove(chunk.Path)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ComparisonSideBySide = "side_by_side"
+	ComparisonWipe       = "wipe"
+
+	ComparisonVideo = "video"
+	ComparisonImage = "image"
+
+	// Frames put in an image strip
+	comparisonStripFrames = 6
+)
+
+// How to render a before/after comparison of a video
+type ComparisonOptions struct {
+	// side_by_side or wipe
+	Mode string `json:"mode"`
+	// video or image (a strip of consecutive frames)
+	Format    string  `json:"format"`
+	Timestamp float64 `json:"timestamp"`
+	Duration  float64 `json:"duration"`
+	// How many times slower the comparison is played
+	Slowdown float64 `json:"slowdown"`
+}
+
+type CompareRequest struct {
+	ComparisonOptions
+	Path string `json:"path" binding:"required"`
+	// Already interpolated video, a preview is generated when empty
+	OutputPath string  `json:"outPath"`
+	TargetFPS  float64 `json:"targetFPS"`
+	ModelPath  string  `json:"modelPath"`
+}
+
+// Verify the options and set defaults
+func (o *ComparisonOptions) verify() error {
+	if o.Mode == "" {
+		o.Mode = ComparisonSideBySide
+	}
+
+	if o.Mode != ComparisonSideBySide && o.Mode != ComparisonWipe {
+		return fmt.Errorf("unknown comparison mode: %s", o.Mode)
+	}
+
+	if o.Format == "" {
+		o.Format = ComparisonVideo
+	}
+
+	if o.Format != ComparisonVideo && o.Format != ComparisonImage {
+		return fmt.Errorf("unknown comparison format: %s", o.Format)
+	}
+
+	if o.Duration <= 0 {
+		o.Duration = 5
+	}
+
+	if o.Slowdown < 1 {
+		o.Slowdown = 1
+	}
+
+	return nil
+}
+
+func (o *ComparisonOptions) Ext() string {
+	if o.Format == ComparisonImage {
+		return ".png"
+	}
+
+	return ".mp4"
+}
+
+func comparisonFilter(options *ComparisonOptions, frameRate float64) string {
+	// The source is brought to the output frame rate (duplicating frames)
+	// so both sides stay in sync
+	prepare := fmt.Sprintf("fps=%f,setpts=%f*(PTS-STARTPTS),format=gbrp", frameRate, options.Slowdown)
+	filter := fmt.Sprintf("[0:v]%s[src];[1:v]%s[out];", prepare, prepare)
+	if options.Mode == ComparisonWipe {
+		// The wipe goes back and forth, source on the left
+		filter += "[src][out]blend=all_expr='if(gte(X,W*(0.5+0.45*sin(T))),B,A)'"
+	} else {
+		filter += "[src][out]hstack=inputs=2"
+	}
+
+	if options.Format == ComparisonImage {
+		filter += fmt.Sprintf(",tile=1x%d", comparisonStripFrames)
+	}
+
+	return filter + ",format=yuv420p[v]"
+}
+
+// Render the comparison of the source (left) and interpolated (right) video,
+// the offsets are where the compared part starts in each video.
+// One ffmpeg graph decodes both, matches their frame rates and stacks them
+func RenderComparison(ctx context.Context, sourcePath string, sourceOffset float64,
+	interpolatedPath string, interpolatedOffset float64, frameRate float64,
+	options *ComparisonOptions, outputPath string) (string, error) {
+	args := []string{
+		"-y",
+		"-ss", formatSeconds(sourceOffset),
+		"-t", formatSeconds(options.Duration),
+		"-i", sourcePath,
+		"-ss", formatSeconds(interpolatedOffset),
+		"-t", formatSeconds(options.Duration),
+		"-i", interpolatedPath,
+		"-filter_complex", comparisonFilter(options, frameRate),
+		"-map", "[v]",
+		"-an",
+	}
+
+	if options.Format == ComparisonImage {
+		args = append(args, "-frames:v", "1")
+	} else {
+		args = append(args, "-c:v", "libx264", "-crf", "18")
+	}
+
+	args = append(args, outputPath)
+	cmd := NewCommandContext(ctx, "ffmpeg", args...)
+	return cmd.CombinedOutput()
+}
+
+func comparisonPath(outputPath string, options *ComparisonOptions) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".comparison" + options.Ext()
+}
+
+// Render the comparison of a finished job next to its output, interpolatedPath
+// is where the output is before it replaces the source of an in-place job
+func (w *Worker) renderJobComparison(video *Video, interpolatedPath string) {
+	if video.Comparison == nil {
+		return
+	}
+
+	options := *video.Comparison
+	if err := options.verify(); err != nil {
+		w.logger.Warn("Invalid comparison options: ", err)
+		return
+	}
+
+	w.updateStep("Rendering comparison")
+	videoInfo, output, err := GetVideoInfo(w.ctx(), interpolatedPath)
+	if err != nil {
+		w.logger.WithField("output", output).Warn("Failed to get output information for comparison: ", err)
+		return
+	}
+
+	outputPath := comparisonPath(video.OutputPath, &options)
+	output, err = RenderComparison(w.ctx(), video.Path, options.Timestamp,
+		interpolatedPath, options.Timestamp, videoInfo.FrameRate, &options, outputPath)
+	if err != nil {
+		w.logger.WithField("output", output).Warn("Failed to render comparison: ", err)
+		return
+	}
+
+	w.logger.WithField("file", outputPath).Info("Comparison rendered")
+}
+
+func createComparison(c *gin.Context) {
+	var request CompareRequest
+	if err := c.ShouldBind(&request); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	if err := request.verify(); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	videoExist, err := PathExist(request.Path)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	if !videoExist {
+		c.String(400, "video source not found")
+		return
+	}
+
+	interpolatedPath := request.OutputPath
+	interpolatedOffset := request.Timestamp
+	if interpolatedPath == "" {
+		// Interpolate only the part that is compared
+		preview, output, err := previewer.Generate(c.Request.Context(), &PreviewRequest{
+			Path:      request.Path,
+			Timestamp: request.Timestamp,
+			Duration:  request.Duration,
+			TargetFPS: request.TargetFPS,
+			ModelPath: request.ModelPath,
+		})
+		if err != nil {
+			log.WithField("output", output).Error("Error generating preview for comparison: ", err)
+			c.String(400, err.Error())
+			return
+		}
+
+		defer os.Remove(preview.Path)
+		interpolatedPath = preview.Path
+		interpolatedOffset = 0
+	}
+
+	videoInfo, output, err := GetVideoInfo(c.Request.Context(), interpolatedPath)
+	if err != nil {
+		log.WithField("output", output).Error("Error getting interpolated video information: ", err)
+		c.String(400, err.Error())
+		return
+	}
+
+	comparison, err := previewer.newPreview(request.Ext())
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	output, err = RenderComparison(c.Request.Context(), request.Path, request.Timestamp,
+		interpolatedPath, interpolatedOffset, videoInfo.FrameRate, &request.ComparisonOptions, comparison.Path)
+	if err != nil {
+		log.WithField("output", output).Error("Error rendering comparison: ", err)
+		_ = os.Remove(comparison.Path)
+		c.String(400, err.Error())
+		return
+	}
+
+	c.JSON(200, comparison)
+}
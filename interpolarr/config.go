@@ -9,22 +9,148 @@ import (
 )
 
 type Config struct {
-	BindAddress                 string        `yaml:"bindAddress"`
-	Port                        int32         `yaml:"port"`
-	DatabasePath                string        `yaml:"databasePath"`
-	LogPath                     string        `yaml:"logPath"`
-	ModelPath                   string        `yaml:"modelPath"`
-	Workers                     int           `yaml:"workers"`
-	TargetFPS                   float64       `yaml:"targetFPS"`
-	FFmpegOptions               FFmpegOptions `yaml:"ffmpegOptions"`
-	DeleteInputFileWhenFinished *bool         `yaml:"deleteInputFileWhenFinished"`
-	DeleteOutputIfAlreadyExist  *bool         `yaml:"deleteOutputIfAlreadyExist"`
-	CopyFileToDestinationOnSkip *bool         `yaml:"copyFileToDestinationOnSkip"`
+	BindAddress                 string             `yaml:"bindAddress"`
+	Port                        int32              `yaml:"port"`
+	DatabasePath                string             `yaml:"databasePath"`
+	LogPath                     string             `yaml:"logPath"`
+	ModelPath                   string             `yaml:"modelPath"`
+	Workers                     int                `yaml:"workers"`
+	TargetFPS                   float64            `yaml:"targetFPS"`
+	FFmpegOptions               FFmpegOptions      `yaml:"ffmpegOptions"`
+	DeleteInputFileWhenFinished *bool              `yaml:"deleteInputFileWhenFinished"`
+	DeleteOutputIfAlreadyExist  *bool              `yaml:"deleteOutputIfAlreadyExist"`
+	CopyFileToDestinationOnSkip *bool              `yaml:"copyFileToDestinationOnSkip"`
+	Chunking                    ChunkingOptions    `yaml:"chunking"`
+	Checkpoint                  CheckpointOptions  `yaml:"checkpoint"`
+	Preview                     PreviewOptions     `yaml:"preview"`
+	Validation                  ValidationOptions  `yaml:"validation"`
+	Schedule                    ScheduleOptions    `yaml:"schedule"`
+	Recovery                    RecoveryOptions    `yaml:"recovery"`
+	Retry                       RetryOptions       `yaml:"retry"`
+	Watch                       WatchOptions       `yaml:"watch"`
+	Skip                        SkipOptions        `yaml:"skip"`
+	Duplicates                  DuplicateOptions   `yaml:"duplicates"`
+	Profiles                    map[string]Profile `yaml:"profiles"`
+	// The first rule matching a video selects its profile
+	Rules []ProfileRule `yaml:"rules"`
+	// Profile of the videos matching no rule
+	DefaultProfile string `yaml:"defaultProfile"`
+}
+
+type ChunkingOptions struct {
+	Enabled *bool `yaml:"enabled"`
+	// Minimum duration in seconds for a video to be split
+	MinDuration float64 `yaml:"minDuration"`
+	// In how many chunks a video is split
+	Chunks int `yaml:"chunks"`
 }
 
 type FFmpegOptions struct {
-	HWAccelDecodeFlag string `yaml:"HWAccelDecodeFlag"`
-	HWAccelEncodeFlag string `yaml:"HWAccelEncodeFlag"`
+	HWAccelDecodeFlag string `yaml:"HWAccelDecodeFlag" json:"hwaccelDecodeFlag,omitempty"`
+	HWAccelEncodeFlag string `yaml:"HWAccelEncodeFlag" json:"hwaccelEncodeFlag,omitempty"`
+	// Encoder of the output
+	VideoCodec string `yaml:"videoCodec" json:"videoCodec,omitempty"`
+	CRF        int    `yaml:"crf" json:"crf,omitempty"`
+}
+
+type CheckpointOptions struct {
+	Enabled *bool `yaml:"enabled"`
+	// Seconds of output video between each checkpoint
+	Interval float64 `yaml:"interval"`
+}
+
+type PreviewOptions struct {
+	Path string `yaml:"path"`
+	// Minutes before a preview is deleted
+	TTL int `yaml:"ttl"`
+}
+
+type ValidationOptions struct {
+	// Maximum frames compared for a validation job
+	MaxSamples int `yaml:"maxSamples"`
+}
+
+type RetryOptions struct {
+	// Attempts before a video fails, the first one included
+	MaxAttempts int `yaml:"maxAttempts"`
+	// Seconds before the first retry, doubled after each retry
+	Backoff float64 `yaml:"backoff"`
+	// Maximum seconds between retries
+	MaxBackoff float64 `yaml:"maxBackoff"`
+}
+
+type RecoveryOptions struct {
+	// Stale files are moved there instead of being deleted when set
+	QuarantinePath string `yaml:"quarantinePath"`
+}
+
+// The videos matching are skipped instead of being interpolated,
+// the limits of 0 are no limit
+type SkipOptions struct {
+	// Skipped when the fps is higher than the target fps minus the tolerance,
+	// 0 only skips the videos at the target fps or above
+	FPSTolerance *float64 `yaml:"fpsTolerance"`
+	// Seconds
+	MinDuration float64 `yaml:"minDuration"`
+	MaxDuration float64 `yaml:"maxDuration"`
+	MinWidth    int     `yaml:"minWidth"`
+	MaxWidth    int     `yaml:"maxWidth"`
+	MinHeight   int     `yaml:"minHeight"`
+	MaxHeight   int     `yaml:"maxHeight"`
+	// Only the codecs allowed are interpolated when set
+	AllowCodecs []string `yaml:"allowCodecs"`
+	DenyCodecs  []string `yaml:"denyCodecs"`
+	// Skip the outputs of interpolarr
+	SkipInterpolated *bool `yaml:"skipInterpolated"`
+}
+
+type DuplicateOptions struct {
+	// process, copy, hardlink or skip the videos with the same
+	// content as a finished video
+	Action string `yaml:"action"`
+	// Chunks of the file hashed for its fingerprint
+	Samples int `yaml:"samples"`
+}
+
+type WatchOptions struct {
+	// Seconds between two scans of the polled folders
+	PollInterval float64       `yaml:"pollInterval"`
+	Folders      []WatchFolder `yaml:"folders"`
+}
+
+type WatchFolder struct {
+	Path       string `yaml:"path"`
+	Recursive  bool   `yaml:"recursive"`
+	FileFilter `yaml:",inline"`
+	// Output path template, same variables as the bulk enqueue
+	OutputTemplate string        `yaml:"outTemplate"`
+	OutRoot        string        `yaml:"outRoot"`
+	Mode           string        `yaml:"mode"`
+	Priority       int           `yaml:"priority"`
+	Settings       VideoSettings `yaml:"settings"`
+	// Selected by the rules when not set
+	Profile string   `yaml:"profile"`
+	Labels  []string `yaml:"labels"`
+	// Scan the folder instead of watching the events, for network mounts
+	Poll bool `yaml:"poll"`
+	// Seconds the file has to stay the same size before it's added
+	StableTime float64 `yaml:"stableTime"`
+}
+
+type ScheduleOptions struct {
+	// Videos are only dispatched during the windows when enabled
+	Enabled *bool            `yaml:"enabled"`
+	Windows []ScheduleWindow `yaml:"windows"`
+}
+
+type ScheduleWindow struct {
+	// When the window starts: minute hour day month weekday
+	Cron string `yaml:"cron"`
+	// Minutes the window lasts
+	Duration int `yaml:"duration"`
+	// finish or pause the running videos when the window ends
+	OnEnd string `yaml:"onEnd"`
+	cron  *cronSpec
 }
 
 // Verify config and set defaults
@@ -72,6 +198,113 @@ func verifyConfig(config *Config) error {
 		config.CopyFileToDestinationOnSkip = &defaultVal
 	}
 
+	if config.Chunking.Enabled == nil {
+		defaultVal := false
+		config.Chunking.Enabled = &defaultVal
+	}
+
+	if config.Chunking.MinDuration == 0 {
+		config.Chunking.MinDuration = 30 * 60
+	}
+
+	if config.Chunking.Chunks == 0 {
+		config.Chunking.Chunks = config.Workers
+	}
+
+	if config.FFmpegOptions.VideoCodec == "" {
+		config.FFmpegOptions.VideoCodec = "h264_nvenc"
+	}
+
+	if config.FFmpegOptions.CRF == 0 {
+		config.FFmpegOptions.CRF = 20
+	}
+
+	if config.Checkpoint.Enabled == nil {
+		defaultVal := true
+		config.Checkpoint.Enabled = &defaultVal
+	}
+
+	if config.Checkpoint.Interval == 0 {
+		config.Checkpoint.Interval = 5 * 60
+	}
+
+	if config.Preview.Path == "" {
+		config.Preview.Path = "./previews"
+	}
+
+	if config.Preview.TTL == 0 {
+		config.Preview.TTL = 60
+	}
+
+	if config.Validation.MaxSamples == 0 {
+		config.Validation.MaxSamples = 1000
+	}
+
+	if config.Schedule.Enabled == nil {
+		defaultVal := false
+		config.Schedule.Enabled = &defaultVal
+	}
+
+	if err := verifySchedule(&config.Schedule, *config.Checkpoint.Enabled); err != nil {
+		return err
+	}
+
+	if config.Retry.MaxAttempts == 0 {
+		config.Retry.MaxAttempts = 6
+	}
+
+	if config.Retry.Backoff == 0 {
+		config.Retry.Backoff = 30
+	}
+
+	if config.Retry.MaxBackoff == 0 {
+		config.Retry.MaxBackoff = 60 * 60
+	}
+
+	if config.Skip.FPSTolerance == nil {
+		defaultVal := 0.1
+		config.Skip.FPSTolerance = &defaultVal
+	}
+
+	if *config.Skip.FPSTolerance < 0 {
+		return errors.New("skip fpsTolerance can't be negative")
+	}
+
+	if config.Skip.SkipInterpolated == nil {
+		defaultVal := true
+		config.Skip.SkipInterpolated = &defaultVal
+	}
+
+	if config.Duplicates.Action == "" {
+		config.Duplicates.Action = DuplicateProcess
+	}
+
+	switch config.Duplicates.Action {
+	case DuplicateProcess, DuplicateCopy, DuplicateHardlink, DuplicateSkip:
+	default:
+		return errors.New("unknown duplicates action: " + config.Duplicates.Action)
+	}
+
+	if config.Duplicates.Samples == 0 {
+		config.Duplicates.Samples = 8
+	}
+
+	if config.Duplicates.Samples < 2 {
+		return errors.New("duplicates samples can't be less than 2")
+	}
+
+	if err := verifyProfiles(config); err != nil {
+		return err
+	}
+
+	if config.Watch.PollInterval == 0 {
+		config.Watch.PollInterval = 60
+	}
+
+	if err := verifyWatch(&config.Watch, config.Profiles); err != nil {
+		return err
+	}
+
 	if config.LogPath == "" {
 		config.LogPath = "./logs"
 	}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// How long an idempotency key returns the same video
+const idempotencyKeyMaxAge = 24 * time.Hour
+
+// Checking for duplicates and inserting is done one video at a time,
+// the videos are verified before
+var enqueueLock sync.Mutex
+
+// Absolute path with the symlinks resolved when it exists
+func normalizePath(path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+
+	if resolved, err := filepath.EvalSymlinks(absPath); err == nil {
+		return resolved
+	}
+
+	return absPath
+}
+
+// A video is a duplicate when it has the same input and mode or the same
+// output as a video that's queued or running
+func isDuplicate(video *Video, other *Video) bool {
+	if video.Mode == other.Mode && normalizePath(video.Path) == normalizePath(other.Path) {
+		return true
+	}
+
+	return video.OutputPath != "" && other.OutputPath != "" &&
+		normalizePath(video.OutputPath) == normalizePath(other.OutputPath)
+}
+
+func findDuplicate(video *Video) (Video, bool) {
+	videos := append(gQueue.GetVideos(), poolWorker.RunningVideos()...)
+	for _, other := range videos {
+		if isDuplicate(video, &other) {
+			// Chunks are part of the video
+			other.Chunk = nil
+			return other, true
+		}
+	}
+
+	return Video{}, false
+}
+
+// Verify the video and set defaults
+func verifyVideo(video *Video) error {
+	// Chunks are only created by the workers
+	video.Chunk = nil
+	video.NotBefore = nil
+	video.Labels = normalizeLabels(video.Labels)
+
+	if err := video.Settings.verify(); err != nil {
+		return err
+	}
+
+	if video.Comparison != nil {
+		if err := video.Comparison.verify(); err != nil {
+			return err
+		}
+	}
+
+	if video.BatchID != 0 {
+		_, ok, err := sqlite.GetBatchByID(video.BatchID)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return errors.New("batch not found")
+		}
+	}
+
+	videoExist, err := PathExist(video.Path)
+	if err != nil {
+		return err
+	}
+
+	if !videoExist {
+		return errors.New("video source not found")
+	}
+
+	if err := applyProfile(video, poolWorker.config); err != nil {
+		return err
+	}
+
+	if video.Mode == "" {
+		video.Mode = poolWorker.config.Profiles[video.Profile].Mode
+	}
+
+	if video.Mode == "" {
+		video.Mode = JobModeInterpolate
+	}
+
+	if video.Mode != JobModeInterpolate && video.Mode != JobModeValidate {
+		return errors.New("unknown mode: " + video.Mode)
+	}
+
+	// TODO: I want to do something to check if the output path
+	// is somewhat valid, but I also want it so that my app
+	// can construct subpath to a video that may not exist yet
+	// example:
+	// show1/episode1 I want it to not error if show1 doesn't exist
+	// since it could create it
+	// videoOutDirExist, err := FileExist(video.OutputPath)
+	// if err != nil {
+	// 	c.String(400, err.Error())
+	// 	return
+	// }
+
+	// if !videoOutDirExist {
+	// 	c.String(400, "video Output path not found")
+	// 	return
+	// }
+
+	return nil
+}
+
+// Verify the video like it would be added, returns the video
+// already queued or running with duplicate set if there's one
+func PlanVideo(video Video) (Video, bool, error) {
+	if err := verifyVideo(&video); err != nil {
+		return Video{}, false, err
+	}
+
+	if existing, duplicate := findDuplicate(&video); duplicate {
+		return existing, true, nil
+	}
+
+	return video, false, nil
+}
+
+// The video created by the first request with the idempotency key
+func findIdempotentVideo(idempotencyKey string) (Video, bool, error) {
+	if idempotencyKey == "" {
+		return Video{}, false, nil
+	}
+
+	id, ok, err := sqlite.GetIdempotencyKey(idempotencyKey, idempotencyKeyMaxAge)
+	if err != nil || !ok {
+		return Video{}, false, err
+	}
+
+	existing, found, err := sqlite.GetVideoByID(id)
+	if err != nil || !found {
+		return Video{}, false, err
+	}
+
+	log.WithField("key", idempotencyKey).Info("Video already added with this idempotency key")
+	return existing, true, nil
+}
+
+// Add the video to the queue, when the same video is already queued or running
+// it is returned instead with duplicate set. With an idempotency key, the video
+// created by the first request with the key is returned
+func EnqueueVideo(video Video, idempotencyKey string) (Video, bool, error) {
+	if existing, found, err := findIdempotentVideo(idempotencyKey); err != nil || found {
+		return existing, found, err
+	}
+
+	// Probing can be slow, it's done before taking the lock
+	if err := verifyVideo(&video); err != nil {
+		return Video{}, false, err
+	}
+
+	enqueueLock.Lock()
+	defer enqueueLock.Unlock()
+
+	// The key could have been used while probing
+	if existing, found, err := findIdempotentVideo(idempotencyKey); err != nil || found {
+		return existing, found, err
+	}
+
+	existing, duplicate := findDuplicate(&video)
+	if duplicate {
+		log.WithFields(StructFields(video)).
+			WithField("existingID", existing.ID).
+			Info("Video is already queued or running")
+		video = existing
+	} else {
+		log.WithFields(StructFields(video)).Debug("Adding video to queue")
+		_, err := sqlite.InsertVideo(&video)
+		if err != nil {
+			log.WithFields(StructFields(video)).Error("Error inserting the video: ", err)
+			return Video{}, false, err
+		}
+
+		gQueue.Enqueue(video)
+		sendBatchUpdate(video.BatchID, true)
+		log.WithFields(StructFields(video)).Info("Sucessfully video to queue")
+	}
+
+	if idempotencyKey != "" {
+		if err := sqlite.InsertIdempotencyKey(idempotencyKey, video.ID); err != nil {
+			log.WithField("key", idempotencyKey).Error("Failed to save idempotency key: ", err)
+		}
+	}
+
+	return video, duplicate, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Failed videos to retry, every failed video when empty
+type RetryFilter struct {
+	// Case insensitive part of the error
+	Error      string `json:"error" form:"error"`
+	PathPrefix string `json:"pathPrefix" form:"pathPrefix"`
+	// The videos with one of the labels
+	Labels []string `json:"labels" form:"label"`
+}
+
+func (f *RetryFilter) Match(failed *FailedVideo) bool {
+	if f.Error != "" && !strings.Contains(strings.ToLower(failed.Error), strings.ToLower(f.Error)) {
+		return false
+	}
+
+	return strings.HasPrefix(failed.Video.Path, f.PathPrefix) && failed.Video.HasAnyLabel(normalizeLabels(f.Labels))
+}
+
+// Put the failed videos back in the queue, returns the videos queued and
+// the videos already queued or running that made others duplicates
+func retryFailed(failedVideos []FailedVideo) ([]Video, []Video, error) {
+	videos := []Video{}
+	duplicates := []Video{}
+	retried := map[int64]bool{}
+	for _, failed := range failedVideos {
+		id := failed.Video.ID
+		if retried[id] {
+			continue
+		}
+
+		retried[id] = true
+		if _, index := gQueue.FindByID(id); index != -1 {
+			continue
+		}
+
+		video, existing, err := retryFailedOne(&failed.Video)
+		if err != nil {
+			return videos, duplicates, err
+		}
+
+		if existing != nil {
+			duplicates = append(duplicates, *existing)
+		} else {
+			videos = append(videos, video)
+		}
+	}
+
+	return videos, duplicates, nil
+}
+
+// Put the failed video back in the queue unless the same video is
+// already queued or running, that video is returned instead
+func retryFailedOne(failed *Video) (Video, *Video, error) {
+	enqueueLock.Lock()
+	defer enqueueLock.Unlock()
+
+	if existing, duplicate := findDuplicate(failed); duplicate {
+		log.WithFields(StructFields(failed)).
+			WithField("existingID", existing.ID).
+			Info("Failed video is already queued or running, not retrying")
+		return Video{}, &existing, nil
+	}
+
+	if err := sqlite.RetryFailedVideo(failed.ID); err != nil {
+		return Video{}, nil, err
+	}
+
+	video, ok, err := sqlite.GetVideoByID(failed.ID)
+	if err != nil {
+		return Video{}, nil, err
+	}
+
+	if !ok {
+		return Video{}, nil, errors.New("video not found")
+	}
+
+	gQueue.Enqueue(video)
+	sendBatchUpdate(video.BatchID, true)
+	log.WithFields(StructFields(video)).Info("Retrying failed video")
+	return video, nil, nil
+}
+
+func retryFailedVideo(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	failedVideos, err := sqlite.GetFailedVideos(false)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	for _, failed := range failedVideos {
+		if failed.ID != id {
+			continue
+		}
+
+		videos, duplicates, err := retryFailed([]FailedVideo{failed})
+		if err != nil {
+			c.String(400, err.Error())
+			return
+		}
+
+		if len(duplicates) != 0 {
+			c.JSON(409, duplicates[0])
+			return
+		}
+
+		c.JSON(200, videos)
+		return
+	}
+
+	c.String(404, "failed video not found")
+}
+
+func retryFailedVideos(c *gin.Context) {
+	// Without a body every failed video is retried
+	var filter RetryFilter
+	if err := c.ShouldBind(&filter); err != nil && err != io.EOF {
+		c.String(400, err.Error())
+		return
+	}
+
+	failedVideos, err := sqlite.GetFailedVideos(false)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	matching := []FailedVideo{}
+	for _, failed := range failedVideos {
+		if filter.Match(&failed) {
+			matching = append(matching, failed)
+		}
+	}
+
+	log.WithFields(StructFields(filter)).WithField("count", len(matching)).Debug("Retrying failed videos")
+	videos, _, err := retryFailed(matching)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	c.JSON(200, videos)
+}
+
+// Delete the failed record, the video stays failed
+func dismissFailedVideo(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	deleted, err := sqlite.DeleteFailedVideo(id)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	if !deleted {
+		c.String(404, "failed video not found")
+		return
+	}
+
+	log.WithField("id", id).Info("Dismissed failed video")
+	c.String(200, "Success")
+}
@@ -5,18 +5,27 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 type FFProbeOutput struct {
 	Streams []struct {
+		CodecName      string `json:"codec_name"`
 		Width          int    `json:"width"`
 		Height         int    `json:"height"`
 		FrameRate      string `json:"r_frame_rate"`
 		FrameCount     string `json:"nb_frames"`
 		FrameCountRead string `json:"nb_read_frames"`
 	} `json:"streams"`
+	Format struct {
+		Duration string            `json:"duration"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
 }
 
 type Frame struct {
@@ -25,10 +34,19 @@ type Frame struct {
 	Height int
 }
 
+// A part of a video, in seconds, used to only process
+// a slice of the input (chunks, previews...)
+type VideoSegment struct {
+	Start    float64
+	Duration float64
+}
+
 type VideoProcessor struct {
 	videoInfo VideoInfo
 	options   FFmpegOptions
 	frameSize int
+	segment   *VideoSegment
+	tags      *InterpolarrTags
 
 	// I/O handlers
 	reader *Command
@@ -38,11 +56,26 @@ type VideoProcessor struct {
 }
 
 type VideoInfo struct {
-	InputPath  string
-	Width      int
-	Height     int
-	FrameRate  float64
-	FrameCount int64
+	InputPath  string  `json:"inputPath"`
+	Codec      string  `json:"codec"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	FrameRate  float64 `json:"frameRate"`
+	FrameCount int64   `json:"frameCount"`
+	Duration   float64 `json:"duration"`
+	// Metadata of the container
+	Tags map[string]string `json:"tags"`
+}
+
+// The tag value, the case of the keys depends on the container
+func (info *VideoInfo) Tag(key string) (string, bool) {
+	for tagKey, value := range info.Tags {
+		if strings.EqualFold(tagKey, key) {
+			return value, true
+		}
+	}
+
+	return "", false
 }
 
 func parseVideoInfoFFProbeOutput(output string) (*FFProbeOutput, error) {
@@ -58,11 +91,13 @@ func parseVideoInfoFFProbeOutput(output string) (*FFProbeOutput, error) {
 	return &probeOutput, nil
 }
 
-func GetVideoInfo(ctx context.Context, inputPath string) (*VideoInfo, string, error) {
+// The info of the video without counting the frames, the frame
+// count is 0 when the container doesn't have it
+func ProbeVideo(ctx context.Context, inputPath string) (*VideoInfo, string, error) {
 	cmd := NewCommandContext(ctx, "ffprobe",
 		"-v", "error",
 		"-select_streams", "v:0",
-		"-show_entries", "stream=width,height,r_frame_rate,nb_frames",
+		"-show_entries", "stream=codec_name,width,height,r_frame_rate,nb_frames:format=duration:format_tags",
 		"-of", "json",
 		inputPath)
 
@@ -94,9 +129,20 @@ func GetVideoInfo(ctx context.Context, inputPath string) (*VideoInfo, string, er
 
 	var videoInfo VideoInfo
 	videoInfo.InputPath = inputPath
+	videoInfo.Codec = mainStream.CodecName
 	videoInfo.Width = mainStream.Width
 	videoInfo.Height = mainStream.Height
 	videoInfo.FrameRate = num / den
+	videoInfo.Tags = ffprobeOutput.Format.Tags
+
+	if ffprobeOutput.Format.Duration != "" && ffprobeOutput.Format.Duration != "N/A" {
+		duration, err := strconv.ParseFloat(ffprobeOutput.Format.Duration, 64)
+		if err != nil {
+			return nil, output, fmt.Errorf("parsing duration: %v", err)
+		}
+
+		videoInfo.Duration = duration
+	}
 
 	if mainStream.FrameCount != "" && mainStream.FrameCount != "N/A" {
 		// container already contains frame count, no need to count
@@ -106,11 +152,20 @@ func GetVideoInfo(ctx context.Context, inputPath string) (*VideoInfo, string, er
 		}
 
 		videoInfo.FrameCount = frameCount
-		return &videoInfo, "", nil
+		setDurationFromFrameCount(&videoInfo)
+	}
+
+	return &videoInfo, "", nil
+}
+
+func GetVideoInfo(ctx context.Context, inputPath string) (*VideoInfo, string, error) {
+	videoInfo, output, err := ProbeVideo(ctx, inputPath)
+	if err != nil || videoInfo.FrameCount != 0 {
+		return videoInfo, output, err
 	}
 
 	// container doesn't have frame count, counting frames
-	cmd = NewCommandContext(ctx, "ffprobe",
+	cmd := NewCommandContext(ctx, "ffprobe",
 		"-v", "error",
 		"-select_streams", "v:0",
 		"-count_frames",
@@ -134,7 +189,95 @@ func GetVideoInfo(ctx context.Context, inputPath string) (*VideoInfo, string, er
 	}
 
 	videoInfo.FrameCount = frameCount
-	return &videoInfo, output, nil
+	setDurationFromFrameCount(videoInfo)
+	return videoInfo, output, nil
+}
+
+// The info of only a segment of the video
+func (info VideoInfo) SegmentInfo(segment VideoSegment) VideoInfo {
+	info.Duration = segment.Duration
+	info.FrameCount = int64(math.Round(segment.Duration * info.FrameRate))
+	return info
+}
+
+// Some containers don't expose a duration, fallback on
+// the frame count to have an approximation
+func setDurationFromFrameCount(videoInfo *VideoInfo) {
+	if videoInfo.Duration == 0 && videoInfo.FrameRate > 0 {
+		videoInfo.Duration = float64(videoInfo.FrameCount) / videoInfo.FrameRate
+	}
+}
+
+// Get the timestamps (in seconds) of every keyframe of the first video stream
+// this only reads the packets so it doesn't need to decode the video
+func GetKeyframeTimes(ctx context.Context, inputPath string) ([]float64, string, error) {
+	cmd := NewCommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "packet=pts_time,flags",
+		"-of", "csv=print_section=0",
+		inputPath)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, output, err
+	}
+
+	keyframes := []float64{}
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.Split(strings.TrimSpace(line), ",")
+		if len(parts) < 2 || !strings.Contains(parts[1], "K") {
+			continue
+		}
+
+		pts, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			// pts can be N/A on some packets, ignore them
+			continue
+		}
+
+		keyframes = append(keyframes, pts)
+	}
+
+	sort.Float64s(keyframes)
+	return keyframes, output, nil
+}
+
+// Losslessly concat video parts (in order) into the output path
+// and remux the audio from the audio source
+func ConcatVideoParts(ctx context.Context, parts []string, audioSourcePath string,
+	outputPath string, tags *InterpolarrTags) (string, error) {
+	listPath := strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".concat.txt"
+	var list strings.Builder
+	for _, part := range parts {
+		absPart, err := filepath.Abs(part)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(absPart, "'", `'\''`))
+	}
+
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return "", err
+	}
+
+	defer os.Remove(listPath)
+	args := []string{
+		"-y",
+		"-f", "concat",
+		"-safe", "0",
+		"-i", listPath,
+		"-i", audioSourcePath,
+		"-map", "0:v",
+		"-map", "1:a?",
+		"-c", "copy",
+	}
+
+	args = append(args, tags.ffmpegArgs(outputPath)...)
+	cmd := NewCommandContext(ctx, "ffmpeg", append(args, outputPath)...)
+
+	return cmd.CombinedOutput()
 }
 
 func NewVideoProcessor(videoInfo *VideoInfo, options FFmpegOptions) (*VideoProcessor, error) {
@@ -147,12 +290,30 @@ func NewVideoProcessor(videoInfo *VideoInfo, options FFmpegOptions) (*VideoProce
 	}, nil
 }
 
+// Write the tags in the outputs with audio
+func (vp *VideoProcessor) SetTags(tags *InterpolarrTags) {
+	vp.tags = tags
+}
+
+// Only read the given segment of the input
+func (vp *VideoProcessor) SetSegment(segment *VideoSegment) {
+	vp.segment = segment
+}
+
 func (vp *VideoProcessor) StartReading(ctx context.Context) error {
 	args := []string{}
 	if vp.options.HWAccelDecodeFlag != "" {
 		args = append(args, "-hwaccel", vp.options.HWAccelDecodeFlag)
 	}
 
+	if vp.segment != nil {
+		args = append(args, "-ss", formatSeconds(vp.segment.Start))
+		// No duration means until the end of the video
+		if vp.segment.Duration > 0 {
+			args = append(args, "-t", formatSeconds(vp.segment.Duration))
+		}
+	}
+
 	args = append(args, "-i", vp.videoInfo.InputPath,
 		"-f", "rawvideo",
 		"-pix_fmt", "rgb24",
@@ -171,23 +332,55 @@ func (vp *VideoProcessor) StartReading(ctx context.Context) error {
 }
 
 func (vp *VideoProcessor) StartWriting(ctx context.Context, outputPath string, outputFrameRate float64) error {
+	return vp.startWriting(ctx, outputPath, outputFrameRate, true)
+}
+
+// Write only the video stream, the audio is remuxed later
+// on when concatenating the parts
+func (vp *VideoProcessor) StartWritingVideoOnly(ctx context.Context, outputPath string, outputFrameRate float64) error {
+	return vp.startWriting(ctx, outputPath, outputFrameRate, false)
+}
+
+func (vp *VideoProcessor) startWriting(ctx context.Context, outputPath string,
+	outputFrameRate float64, withAudio bool) error {
 	args := []string{
 		"-f", "rawvideo",
 		"-pix_fmt", "rgb24",
 		"-video_size", fmt.Sprintf("%dx%d", vp.videoInfo.Width, vp.videoInfo.Height),
 		"-framerate", fmt.Sprintf("%f", outputFrameRate),
 		"-i", "pipe:0",
-		"-i", vp.videoInfo.InputPath,
 	}
+	if withAudio {
+		// Only the audio of the segment is kept
+		if vp.segment != nil {
+			args = append(args, "-ss", formatSeconds(vp.segment.Start))
+			if vp.segment.Duration > 0 {
+				args = append(args, "-t", formatSeconds(vp.segment.Duration))
+			}
+		}
+
+		args = append(args, "-i", vp.videoInfo.InputPath)
+	}
+
 	if vp.options.HWAccelDecodeFlag != "" {
 		args = append(args, "-c:v", vp.options.HWAccelEncodeFlag)
 	}
 
-	args = append(args, "-c:v", "h264_nvenc",
-		"-c:a", "copy",
-		"-crf", "20",
-		"-pix_fmt", "yuv420p",
-		outputPath)
+	args = append(args, "-c:v", vp.options.VideoCodec)
+	if withAudio {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-an")
+	}
+
+	args = append(args,
+		"-crf", strconv.Itoa(vp.options.CRF),
+		"-pix_fmt", "yuv420p")
+	if withAudio {
+		args = append(args, vp.tags.ffmpegArgs(outputPath)...)
+	}
+
+	args = append(args, outputPath)
 
 	vp.writer = NewCommandContext(ctx, "ffmpeg", args...)
 
@@ -200,6 +393,28 @@ func (vp *VideoProcessor) StartWriting(ctx context.Context, outputPath string, o
 	return vp.writer.Start()
 }
 
+// Close the writer and wait for the output to be fully flushed,
+// another output can be started with StartWriting after
+func (vp *VideoProcessor) CloseWriting() error {
+	if vp.stdin == nil {
+		return nil
+	}
+
+	err := vp.stdin.Close()
+	vp.stdin = nil
+	if err != nil {
+		return fmt.Errorf("closing stdin: %v", err)
+	}
+
+	err = vp.writer.Wait()
+	vp.writer = nil
+	if err != nil {
+		return fmt.Errorf("waiting for writer: %v", err)
+	}
+
+	return nil
+}
+
 func (vp *VideoProcessor) ReadFrame() (Frame, error) {
 	buf := make([]byte, vp.frameSize)
 	_, err := io.ReadFull(vp.stdout, buf)
@@ -246,6 +461,10 @@ func (vp *VideoProcessor) Close() error {
 	return nil
 }
 
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 6, 64)
+}
+
 // Getters for video properties
 func (vp *VideoProcessor) Width() int         { return vp.videoInfo.Width }
 func (vp *VideoProcessor) Height() int        { return vp.videoInfo.Height }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// What is done with a video that has the same content as a finished video
+const (
+	DuplicateProcess  = "process"
+	DuplicateCopy     = "copy"
+	DuplicateHardlink = "hardlink"
+	DuplicateSkip     = "skip"
+)
+
+// Bytes hashed for each sample of the fingerprint
+const fingerprintSampleSize = 64 * 1024
+
+// A fast fingerprint of the content of the file: its size and the hash of
+// samples spread evenly over it. Small files are hashed whole
+func Fingerprint(path string, samples int) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	size := info.Size()
+	hash := sha256.New()
+	binary.Write(hash, binary.LittleEndian, size)
+	if size <= int64(samples)*fingerprintSampleSize {
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", err
+		}
+	} else {
+		// The first sample is at the start and the last one at the end
+		step := (size - fingerprintSampleSize) / int64(samples-1)
+		for i := 0; i < samples; i++ {
+			section := io.NewSectionReader(file, int64(i)*step, fingerprintSampleSize)
+			if _, err := io.Copy(hash, section); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return fmt.Sprintf("%d-%x", size, hash.Sum(nil)), nil
+}
+
+// A finished video with the same content whose output can be reused, nil
+// when there is none
+func (w *Worker) findDuplicate(video *Video, fingerprint string, settings *VideoSettings) (*Video, error) {
+	candidates, err := sqlite.GetDoneVideosByFingerprint(fingerprint, video.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range candidates {
+		candidate := &candidates[i]
+		if exist, _ := PathExist(candidate.OutputPath); !exist {
+			continue
+		}
+
+		// The output has to be interpolated the same way
+		ctx, cancel := context.WithTimeout(w.ctx(), ruleProbeTimeout)
+		outputInfo, _, err := ProbeVideo(ctx, candidate.OutputPath)
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		tags := ReadInterpolarrTags(outputInfo)
+		if tags != nil && tags.TargetFPS == settings.TargetFPS && tags.Model == filepath.Base(settings.ModelPath) {
+			return candidate, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Reuse the output of the duplicate for the video as configured, nil when
+// the video has to be processed
+func (w *Worker) reuseDuplicate(video *Video, outputPath string, useTmpFile bool,
+	settings *VideoSettings) *ProcessVideoOutput {
+	options := w.poolWorker.config.Duplicates
+	fingerprint, err := Fingerprint(video.Path, options.Samples)
+	if err != nil {
+		w.logger.Error("Failed to compute the fingerprint: ", err)
+		return nil
+	}
+
+	if err := sqlite.SetVideoFingerprint(video, fingerprint); err != nil {
+		w.logger.Error("Failed to save the fingerprint: ", err)
+	}
+
+	if options.Action == DuplicateProcess {
+		return nil
+	}
+
+	duplicate, err := w.findDuplicate(video, fingerprint, settings)
+	if err != nil {
+		w.logger.Error("Failed to look for a duplicate: ", err)
+		return nil
+	}
+
+	if duplicate == nil {
+		return nil
+	}
+
+	logger := w.logger.WithField("duplicateId", duplicate.ID).WithField("action", options.Action)
+	logger.Info("Video has the same content as a finished video")
+	if options.Action == DuplicateSkip {
+		return &ProcessVideoOutput{skip: true, skipReason: fmt.Sprintf("same content as video %d", duplicate.ID)}
+	}
+
+	if ok, _ := IsSamePath(duplicate.OutputPath, video.OutputPath); ok {
+		return &ProcessVideoOutput{skipReason: fmt.Sprintf("output of video %d is already there", duplicate.ID)}
+	}
+
+	reused := "copied"
+	if options.Action == DuplicateHardlink {
+		if err := os.Link(duplicate.OutputPath, outputPath); err != nil {
+			logger.Warn("Failed to hardlink the output, copying it instead: ", err)
+		} else {
+			reused = "hardlinked"
+		}
+	}
+
+	if reused == "copied" {
+		if err := CopyFile(duplicate.OutputPath, outputPath); err != nil {
+			logger.Error("Failed to copy the output, processing the video: ", err)
+			_ = os.Remove(outputPath)
+			return nil
+		}
+	}
+
+	w.renderJobComparison(video, outputPath)
+	if useTmpFile {
+		if err := RenameOverwrite(outputPath, video.OutputPath); err != nil {
+			logger.Error("Failed to move the output, processing the video: ", err)
+			_ = os.Remove(outputPath)
+			return nil
+		}
+	}
+
+	return &ProcessVideoOutput{skipReason: fmt.Sprintf("output %s from video %d", reused, duplicate.ID)}
+}
@@ -3,6 +3,7 @@ module github.com/Zelak312/interpolarr/interpolarr
 go 1.23.0
 
 require (
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/gin-contrib/static v1.1.2
 	github.com/gin-gonic/gin v1.9.1
 	github.com/gorilla/websocket v1.5.3
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HistoryDone      = "done"
+	HistorySkipped   = "skipped"
+	HistoryFailed    = "failed"
+	HistoryCancelled = "cancelled"
+)
+
+// A video that is finished
+type HistoryEntry struct {
+	Video Video `json:"video"`
+	// done, skipped, failed or cancelled
+	Status     string     `json:"status"`
+	SkipReason string     `json:"skipReason,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+type HistoryQuery struct {
+	Status string `form:"status"`
+	// Only the videos with one of the labels
+	Labels []string `form:"label"`
+	Limit  int      `form:"limit"`
+}
+
+func listHistory(c *gin.Context) {
+	query := HistoryQuery{Limit: 100}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	entries, err := sqlite.GetHistory(query.Status, normalizeLabels(query.Labels), query.Limit)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	c.JSON(200, entries)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LabelsRequest struct {
+	Labels []string `json:"labels" form:"labels"`
+}
+
+// Trimmed labels without the empty ones and the duplicates, case insensitive
+func normalizeLabels(labels []string) []string {
+	normalized := []string{}
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label != "" && !containsFold(normalized, label) {
+			normalized = append(normalized, label)
+		}
+	}
+
+	return normalized
+}
+
+// The video has one of the labels, every video matches when there are none
+func (v *Video) HasAnyLabel(labels []string) bool {
+	if len(labels) == 0 {
+		return true
+	}
+
+	for _, label := range v.Labels {
+		if containsFold(labels, label) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Replace the labels of a video, queued, running or finished
+func setVideoLabels(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	var request LabelsRequest
+	if err := c.ShouldBind(&request); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	video, ok, err := sqlite.GetVideoByID(id)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	if !ok {
+		c.String(404, "video not found")
+		return
+	}
+
+	video.Labels = normalizeLabels(request.Labels)
+	if err := sqlite.UpdateVideoLabels(&video); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	gQueue.SetLabels(id, video.Labels)
+	poolWorker.SetVideoLabels(id, video.Labels)
+	log.WithField("id", id).WithField("labels", video.Labels).Info("Sucessfully changed video labels")
+	c.JSON(200, video)
+}
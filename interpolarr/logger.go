@@ -35,6 +35,14 @@ func InitLogFile(logPath string) error {
 	return err
 }
 
+func CloseLogFile() error {
+	if logFile == nil {
+		return nil
+	}
+
+	return logFile.Close()
+}
+
 func CreateLogger(name string) (*logrus.Entry, error) {
 	if logFile == nil {
 		return nil, errors.New("log file was not initiated")
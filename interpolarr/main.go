@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"embed"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
@@ -22,6 +23,28 @@ type Video struct {
 	ID         int64  `json:"id"`
 	Path       string `json:"path"`
 	OutputPath string `json:"outPath"`
+	// interpolate or validate
+	Mode string `json:"mode"`
+	// Higher priorities are processed first
+	Priority int `json:"priority"`
+	// Processed even outside of the schedule windows
+	RunNow bool `json:"runNow"`
+	// Not processed before this time when retried
+	NotBefore *time.Time `json:"notBefore,omitempty" binding:"-"`
+	// Overrides of the config for this video
+	Settings VideoSettings `json:"settings"`
+	// Selected by the rules when not set
+	Profile string `json:"profile,omitempty"`
+	// Name of the rule that selected the profile
+	Rule string `json:"rule,omitempty"`
+	// Batch the video was added to, 0 when none
+	BatchID int64 `json:"batchId,omitempty"`
+	// Free-form, like the system that added the video
+	Labels []string `json:"labels,omitempty"`
+	// Render a before/after comparison when the video is done
+	Comparison *ComparisonOptions `json:"comparison,omitempty"`
+	// Only set on sub jobs of a video split into chunks
+	Chunk *VideoChunk `json:"chunk,omitempty" binding:"-"`
 }
 
 type FailedVideo struct {
@@ -29,15 +52,22 @@ type FailedVideo struct {
 	Video        Video  `json:"video"`
 	FFmpegOutput string `json:"ffmpegOutput"`
 	Error        string `json:"error"`
+	// The video was retried since
+	Archived bool `json:"archived"`
 }
 
 var gQueue Queue
 var poolWorker *PoolWorker
+var previewer *Previewer
+var watcher *Watcher
 var hub *Hub
 var sqlite Sqlite
 
 var log *logrus.Entry
 
+// How long the workers have to stop before exiting forcefully
+const shutdownTimeout = 30 * time.Second
+
 func setupLoggers(config *Config) {
 	err := InitLogFile(config.LogPath)
 	if err != nil {
@@ -108,11 +138,49 @@ func main() {
 
 		api.GET("/queue", listVideoQueue)
 		api.POST("/queue", addVideoToQueue)
+		api.POST("/queue/bulk", addVideosToQueue)
 		api.DELETE("/queue/:id", delVideoToQueue)
+		api.POST("/queue/:id/cancel", cancelVideo)
+		api.POST("/queue/:id/move", moveVideoInQueue)
+		api.PUT("/queue/:id/priority", setVideoPriority)
+		api.POST("/queue/:id/run_now", runVideoNow)
 
 		api.GET("/workers", listWorkers)
+		api.PUT("/workers/count", setWorkerCount)
+		api.POST("/workers/:id/pause", pauseWorker)
+		api.POST("/workers/:id/resume", resumeWorker)
+
+		api.GET("/dispatcher", getDispatcher)
+		api.POST("/dispatcher/pause", pauseDispatcher)
+		api.POST("/dispatcher/resume", resumeDispatcher)
+
+		api.GET("/schedule", getSchedule)
 
 		api.GET("/failed_videos", listFailedVideos)
+		api.POST("/failed_videos/retry", retryFailedVideos)
+		api.POST("/failed_videos/:id/retry", retryFailedVideo)
+		api.DELETE("/failed_videos/:id", dismissFailedVideo)
+		api.GET("/videos/:id/attempts", listVideoAttempts)
+		api.PUT("/videos/:id/labels", setVideoLabels)
+		api.GET("/history", listHistory)
+
+		api.GET("/batches", listBatches)
+		api.POST("/batches", createBatch)
+		api.GET("/batches/:id", getBatch)
+		api.POST("/batches/:id/pause", pauseBatch)
+		api.POST("/batches/:id/resume", resumeBatch)
+		api.POST("/batches/:id/cancel", cancelBatch)
+		api.POST("/batches/:id/retry", retryBatch)
+		api.GET("/probe", probeVideo)
+
+		api.POST("/recovery/sweep", sweepStaleFiles)
+
+		api.POST("/preview", createPreview)
+		api.GET("/preview/:id", getPreview)
+		api.POST("/compare", createComparison)
+
+		api.GET("/validations", listValidationResults)
+		api.GET("/validations/:id", getValidationResult)
 
 		api.GET("/ws", func(c *gin.Context) {
 			hub.HandleConnections(c)
@@ -122,32 +190,94 @@ func main() {
 	ctx, ctxCancel := context.WithCancel(context.Background())
 	sigs := make(chan os.Signal, 1)
 	poolWorker = NewPoolWorker(ctx, &gQueue, &config, hub)
+	previewer, err = NewPreviewer(&config, poolWorker)
+	if err != nil {
+		log.Panic("Error creating the previewer: ", err)
+	}
+
+	watcher, err = NewWatcher(ctx, &config)
+	if err != nil {
+		log.Panic("Error creating the watcher: ", err)
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", config.BindAddress, config.Port),
+		Handler: r,
+	}
+
+	exitCode := make(chan int)
+	// Nothing is running yet, every processing file left is from a crash
+	report, err := RecoverFiles(&config, &gQueue, map[int64]bool{})
+	if err != nil {
+		log.Error("Failed to recover files: ", err)
+	} else {
+		logRecoveryReport(&report)
+	}
 
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigs
 		log.Info("Signal received: ", sig, " shuting down")
-		ctxCancel()
-
-		timer := time.NewTimer(time.Second * 30)
-		go func() {
-			<-timer.C
-			log.Info("Taking too long to shutdown, exiting forcefully")
-			os.Exit(1)
-		}()
-
-		poolWorker.waitGroup.Wait()
-		os.Exit(1)
+		exitCode <- shutdown(server, ctxCancel)
 	}()
 
 	// Start running things
 	go poolWorker.RunDispatcherBlocking()
+	go previewer.RunCleanupBlocking(ctx)
+	go watcher.RunBlocking()
 
 	log.Infof("Starting dashboard and api on %s:%d", config.BindAddress, config.Port)
-	err = r.Run(fmt.Sprintf("%s:%d", config.BindAddress, config.Port))
-	if err != nil {
+	err = server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		log.Panic("Error running web server: ", err)
 	}
+
+	os.Exit(<-exitCode)
+}
+
+// Stop the web server and the workers, the interrupted videos stay
+// in the queue. Returns the exit code
+func shutdown(server *http.Server, cancel context.CancelFunc) int {
+	ctx, cancelTimeout := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelTimeout()
+
+	log.Info("Stopping the web server")
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error("Failed to stop the web server: ", err)
+	}
+
+	log.Info("Stopping the workers")
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		poolWorker.waitGroup.Wait()
+		watcher.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Error("Taking too long to shutdown, exiting forcefully")
+		return 1
+	}
+
+	poolWorker.RemoveChunkedJobs()
+	// The interrupted videos were put back in front of the queue
+	if err := sqlite.UpdateQueuePositions(gQueue.GetVideos()); err != nil {
+		log.Error("Failed to save queue positions: ", err)
+	}
+
+	if err := sqlite.Close(); err != nil {
+		log.Error("Failed to close the database: ", err)
+	}
+
+	log.Info("Shutdown complete")
+	if err := CloseLogFile(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to close the log file: ", err)
+	}
+
+	return 0
 }
 
 func ping(c *gin.Context) {
@@ -164,49 +294,115 @@ func addVideoToQueue(c *gin.Context) {
 		return
 	}
 
-	videoExist, err := PathExist(video.Path)
+	video, duplicate, err := EnqueueVideo(video, c.GetHeader("Idempotency-Key"))
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	if duplicate {
+		c.Header("X-Duplicate", "true")
+	}
+
+	c.JSON(200, video)
+}
+
+func delVideoToQueue(c *gin.Context) {
+	idS := c.Param("id")
+	id, err := strconv.ParseInt(idS, 10, 64)
 	if err != nil {
 		c.String(400, err.Error())
 		return
 	}
 
-	if !videoExist {
-		c.String(400, "video source not found")
+	log.WithField("id", id).Debug("Deleting video by id")
+	// Chunks of a split video share its id, they are all removed
+	// and the running ones are canceled
+	videos := gQueue.RemoveAllByID(id)
+	running := poolWorker.CancelVideo(id)
+	if len(videos) == 0 && !running {
+		log.WithField("id", id).Error("Failed to delete the video by id: not queued or running")
+		c.String(400, "Didn't find video")
 		return
 	}
 
-	// TODO: I want to do something to check if the output path
-	// is somewhat valid, but I also want it so that my app
-	// can construct subpath to a video that may not exist yet
-	// example:
-	// show1/episode1 I want it to not error if show1 doesn't exist
-	// since it could create it
-	// videoOutDirExist, err := FileExist(video.OutputPath)
-	// if err != nil {
-	// 	c.String(400, err.Error())
-	// 	return
-	// }
+	// The worker clears the checkpoints of a running video once it stopped
+	if !running {
+		err = ClearCheckpoints(&Video{ID: id})
+		if err != nil {
+			c.String(400, err.Error())
+			return
+		}
+	}
+
+	err = sqlite.DeleteVideoByID(nil, id)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
 
-	// if !videoOutDirExist {
-	// 	c.String(400, "video Output path not found")
-	// 	return
-	// }
+	video := Video{ID: id}
+	if len(videos) > 0 {
+		video = videos[0]
+		video.Chunk = nil
+	}
 
-	log.WithFields(StructFields(video)).Debug("Adding video to queue")
+	log.WithField("id", id).Info("Sucessfully delete video by id")
+	c.JSON(200, video)
+}
 
-	_, err = sqlite.InsertVideo(&video)
+func cancelVideo(c *gin.Context) {
+	idS := c.Param("id")
+	id, err := strconv.ParseInt(idS, 10, 64)
 	if err != nil {
-		log.WithFields(StructFields(video)).Error("Error inserting the video: ", err)
 		c.String(400, err.Error())
 		return
 	}
 
-	gQueue.Enqueue(video)
-	log.WithFields(StructFields(video)).Info("Sucessfully video to queue")
+	if err := cancelVideoByID(id); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
 	c.String(200, "Success")
 }
 
-func delVideoToQueue(c *gin.Context) {
+// Cancel the video whether it's running or queued
+func cancelVideoByID(id int64) error {
+	log.WithField("id", id).Debug("Canceling video by id")
+	if poolWorker.CancelVideo(id) {
+		// The worker cleans up and marks the video as canceled
+		log.WithField("id", id).Info("Sucessfully canceled running video")
+		return nil
+	}
+
+	video, ok := gQueue.RemoveByID(id)
+	if !ok {
+		return errors.New("Didn't find video")
+	}
+
+	err := ClearCheckpoints(&video)
+	if err != nil {
+		log.WithField("id", id).Error("Failed to clear checkpoints: ", err)
+	}
+
+	err = sqlite.CancelVideo(&video)
+	if err != nil {
+		return err
+	}
+
+	sendBatchUpdate(video.BatchID, true)
+	log.WithField("id", id).Info("Sucessfully canceled queued video")
+	return nil
+}
+
+type MoveVideoRequest struct {
+	// top or bottom
+	To    string `json:"to" form:"to"`
+	Index *int   `json:"index" form:"index"`
+}
+
+func moveVideoInQueue(c *gin.Context) {
 	idS := c.Param("id")
 	id, err := strconv.ParseInt(idS, 10, 64)
 	if err != nil {
@@ -214,27 +410,116 @@ func delVideoToQueue(c *gin.Context) {
 		return
 	}
 
-	log.WithField("id", id).Debug("Deleting video by id")
-	err = sqlite.DeleteVideoByID(nil, id)
+	var request MoveVideoRequest
+	if err := c.ShouldBind(&request); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	index := -1
+	switch {
+	case request.To == "top":
+		index = 0
+	case request.To == "bottom":
+		index = -1
+	case request.Index != nil:
+		index = *request.Index
+	default:
+		c.String(400, "to (top or bottom) or index is required")
+		return
+	}
+
+	log.WithField("id", id).WithField("index", index).Debug("Moving video in queue")
+	video, ok := gQueue.Move(id, index)
+	if !ok {
+		c.String(400, "Didn't find video")
+		return
+	}
+
+	err = sqlite.UpdateQueuePositions(gQueue.GetVideos())
 	if err != nil {
+		log.WithField("id", id).Error("Failed to save queue positions: ", err)
 		c.String(400, err.Error())
 		return
 	}
 
-	video, ok := gQueue.RemoveByID(id)
+	log.WithField("id", id).Info("Sucessfully moved video in queue")
+	c.JSON(200, video)
+}
+
+type PriorityRequest struct {
+	Priority *int `json:"priority" form:"priority" binding:"required"`
+}
+
+func setVideoPriority(c *gin.Context) {
+	idS := c.Param("id")
+	id, err := strconv.ParseInt(idS, 10, 64)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	var request PriorityRequest
+	if err := c.ShouldBind(&request); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	log.WithField("id", id).WithField("priority", *request.Priority).Debug("Changing video priority")
+	video, ok := gQueue.SetPriority(id, *request.Priority)
 	if !ok {
-		log.WithField("id", id).Error("Failed to delete the video by id: ", err)
 		c.String(400, "Didn't find video")
 		return
 	}
 
-	log.WithField("id", id).Info("Sucessfully delete video by id")
+	err = sqlite.UpdateQueuePositions(gQueue.GetVideos())
+	if err != nil {
+		log.WithField("id", id).Error("Failed to save queue positions: ", err)
+		c.String(400, err.Error())
+		return
+	}
+
+	log.WithField("id", id).Info("Sucessfully changed video priority")
+	c.JSON(200, video)
+}
+
+func runVideoNow(c *gin.Context) {
+	idS := c.Param("id")
+	id, err := strconv.ParseInt(idS, 10, 64)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	log.WithField("id", id).Debug("Running video now")
+	video, ok := gQueue.SetRunNow(id, true)
+	if !ok {
+		c.String(400, "Didn't find video")
+		return
+	}
+
+	err = sqlite.UpdateVideoRunNow(&video)
+	if err != nil {
+		log.WithField("id", id).Error("Failed to save run now: ", err)
+		c.String(400, err.Error())
+		return
+	}
+
+	log.WithField("id", id).Info("Sucessfully set video to run now")
 	c.JSON(200, video)
 }
 
 func listVideoQueue(c *gin.Context) {
 	log.Debug("Getting video queue")
-	c.JSON(200, gQueue.GetVideos())
+	labels := normalizeLabels(c.QueryArray("label"))
+	videos := []Video{}
+	for _, video := range gQueue.GetVideos() {
+		if video.HasAnyLabel(labels) {
+			videos = append(videos, video)
+		}
+	}
+
+	c.JSON(200, videos)
 }
 
 func listWorkers(c *gin.Context) {
@@ -242,13 +527,101 @@ func listWorkers(c *gin.Context) {
 	c.JSON(200, poolWorker.GetWorkerInfos())
 }
 
+func pauseWorker(c *gin.Context) {
+	setWorkerPaused(c, true)
+}
+
+func resumeWorker(c *gin.Context) {
+	setWorkerPaused(c, false)
+}
+
+func setWorkerPaused(c *gin.Context, paused bool) {
+	idS := c.Param("id")
+	id, err := strconv.Atoi(idS)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	log.WithField("id", id).WithField("paused", paused).Debug("Changing worker paused state")
+	// Pausing doesn't stop the current video unless asked
+	cancel := c.Query("cancel") == "true"
+	info, err := poolWorker.SetWorkerPaused(id, paused, cancel)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	log.WithField("id", id).WithField("paused", paused).Info("Sucessfully changed worker paused state")
+	c.JSON(200, info)
+}
+
+type WorkerCountRequest struct {
+	Count int `json:"count" form:"count" binding:"required"`
+}
+
+func setWorkerCount(c *gin.Context) {
+	var request WorkerCountRequest
+	if err := c.ShouldBind(&request); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	log.WithField("count", request.Count).Debug("Changing worker count")
+	err := poolWorker.SetWorkerCount(request.Count)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	log.WithField("count", request.Count).Info("Sucessfully changed worker count")
+	c.JSON(200, poolWorker.GetWorkerInfos())
+}
+
+func getDispatcher(c *gin.Context) {
+	c.JSON(200, poolWorker.GetDispatcherInfo())
+}
+
+func getSchedule(c *gin.Context) {
+	c.JSON(200, poolWorker.GetDispatcherInfo().Schedule)
+}
+
+func pauseDispatcher(c *gin.Context) {
+	setDispatcherPaused(c, true)
+}
+
+func resumeDispatcher(c *gin.Context) {
+	setDispatcherPaused(c, false)
+}
+
+func setDispatcherPaused(c *gin.Context, paused bool) {
+	log.WithField("paused", paused).Debug("Changing dispatcher paused state")
+	err := poolWorker.SetPaused(paused)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	log.WithField("paused", paused).Info("Sucessfully changed dispatcher paused state")
+	c.JSON(200, poolWorker.GetDispatcherInfo())
+}
+
 func listFailedVideos(c *gin.Context) {
 	log.Debug("Getting failed video list")
-	failedVids, err := sqlite.GetFailedVideos()
+	archived := c.Query("archived") == "true"
+	failedVids, err := sqlite.GetFailedVideos(archived)
 	if err != nil {
 		c.String(400, err.Error())
 		return
 	}
 
-	c.JSON(200, failedVids)
+	labels := normalizeLabels(c.QueryArray("label"))
+	matching := []FailedVideo{}
+	for _, failed := range failedVids {
+		if failed.Video.HasAnyLabel(labels) {
+			matching = append(matching, failed)
+		}
+	}
+
+	c.JSON(200, matching)
 }
@@ -0,0 +1 @@
+DROP TABLE video_checkpoints;
\ No newline at end of file
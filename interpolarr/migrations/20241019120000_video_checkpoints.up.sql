@@ -0,0 +1,7 @@
+CREATE TABLE video_checkpoints (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    video_id INTEGER NOT NULL,
+    part_path TEXT NOT NULL,
+    last_frame INTEGER NOT NULL,
+    FOREIGN KEY (video_id) REFERENCES videos(id)
+);
\ No newline at end of file
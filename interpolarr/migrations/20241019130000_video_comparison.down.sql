@@ -0,0 +1 @@
+ALTER TABLE videos DROP COLUMN comparison;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE videos
+ADD comparison TEXT;
\ No newline at end of file
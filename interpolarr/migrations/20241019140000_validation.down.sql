@@ -0,0 +1,2 @@
+DROP TABLE validation_results;
+ALTER TABLE videos DROP COLUMN mode;
\ No newline at end of file
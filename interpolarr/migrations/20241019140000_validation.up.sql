@@ -0,0 +1,13 @@
+ALTER TABLE videos
+ADD mode TEXT DEFAULT 'interpolate';
+CREATE TABLE validation_results (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    video_id INTEGER NOT NULL,
+    model_path TEXT NOT NULL,
+    samples INTEGER NOT NULL,
+    psnr REAL NOT NULL,
+    ssim REAL NOT NULL,
+    min_psnr REAL NOT NULL,
+    min_ssim REAL NOT NULL,
+    FOREIGN KEY (video_id) REFERENCES videos(id)
+);
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE videos DROP COLUMN position;
+ALTER TABLE videos DROP COLUMN priority;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE videos
+ADD priority INTEGER DEFAULT 0;
+ALTER TABLE videos
+ADD position INTEGER DEFAULT 0;
+UPDATE videos SET position = id;
\ No newline at end of file
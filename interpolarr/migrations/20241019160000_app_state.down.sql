@@ -0,0 +1 @@
+DROP TABLE app_state;
\ No newline at end of file
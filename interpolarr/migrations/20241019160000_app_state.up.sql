@@ -0,0 +1,4 @@
+CREATE TABLE app_state (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
\ No newline at end of file
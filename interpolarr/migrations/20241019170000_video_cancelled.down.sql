@@ -0,0 +1 @@
+ALTER TABLE videos DROP COLUMN cancelled;
\ No newline at end of file
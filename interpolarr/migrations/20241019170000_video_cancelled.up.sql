@@ -0,0 +1,2 @@
+ALTER TABLE videos
+ADD cancelled BOOLEAN DEFAULT 0;
\ No newline at end of file
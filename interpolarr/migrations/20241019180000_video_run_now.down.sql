@@ -0,0 +1 @@
+ALTER TABLE videos DROP COLUMN run_now;
\ No newline at end of file
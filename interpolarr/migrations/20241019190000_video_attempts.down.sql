@@ -0,0 +1,2 @@
+DROP TABLE video_attempts;
+ALTER TABLE videos DROP COLUMN not_before;
\ No newline at end of file
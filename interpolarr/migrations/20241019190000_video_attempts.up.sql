@@ -0,0 +1,15 @@
+ALTER TABLE videos
+ADD not_before INTEGER;
+CREATE TABLE video_attempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    video_id INTEGER NOT NULL,
+    attempt INTEGER NOT NULL,
+    chunk INTEGER,
+    started_at INTEGER NOT NULL,
+    ended_at INTEGER NOT NULL,
+    result TEXT NOT NULL,
+    error TEXT NOT NULL,
+    permanent BOOLEAN DEFAULT 0,
+    retry_at INTEGER,
+    FOREIGN KEY (video_id) REFERENCES videos(id)
+);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE failed_videos DROP COLUMN archived;
\ No newline at end of file
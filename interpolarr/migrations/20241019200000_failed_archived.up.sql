@@ -0,0 +1,2 @@
+ALTER TABLE failed_videos
+ADD archived BOOLEAN DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE idempotency_keys (
+    key TEXT PRIMARY KEY,
+    video_id INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    FOREIGN KEY (video_id) REFERENCES videos(id)
+);
\ No newline at end of file
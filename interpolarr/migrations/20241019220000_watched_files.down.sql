@@ -0,0 +1 @@
+DROP TABLE watched_files;
\ No newline at end of file
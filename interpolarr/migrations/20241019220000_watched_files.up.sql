@@ -0,0 +1,8 @@
+CREATE TABLE watched_files (
+    path TEXT PRIMARY KEY,
+    size INTEGER NOT NULL,
+    mod_time INTEGER NOT NULL,
+    video_id INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    FOREIGN KEY (video_id) REFERENCES videos(id)
+);
\ No newline at end of file
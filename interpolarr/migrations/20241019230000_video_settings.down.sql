@@ -0,0 +1 @@
+ALTER TABLE videos DROP COLUMN settings;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE videos
+ADD settings TEXT;
\ No newline at end of file
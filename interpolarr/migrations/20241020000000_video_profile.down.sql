@@ -0,0 +1,2 @@
+ALTER TABLE videos DROP COLUMN rule;
+ALTER TABLE videos DROP COLUMN profile;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE videos
+ADD profile TEXT NOT NULL DEFAULT '';
+ALTER TABLE videos
+ADD rule TEXT NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE videos DROP COLUMN finished_at;
+ALTER TABLE videos DROP COLUMN skip_reason;
\ No newline at end of file
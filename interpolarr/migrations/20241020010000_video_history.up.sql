@@ -0,0 +1,4 @@
+ALTER TABLE videos
+ADD skip_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE videos
+ADD finished_at INTEGER;
\ No newline at end of file
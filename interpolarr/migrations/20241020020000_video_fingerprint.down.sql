@@ -0,0 +1,2 @@
+DROP INDEX videos_fingerprint;
+ALTER TABLE videos DROP COLUMN fingerprint;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE videos
+ADD fingerprint TEXT NOT NULL DEFAULT '';
+CREATE INDEX videos_fingerprint ON videos (fingerprint);
\ No newline at end of file
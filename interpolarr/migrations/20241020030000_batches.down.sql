@@ -0,0 +1,2 @@
+ALTER TABLE videos DROP COLUMN batch_id;
+DROP TABLE batches;
\ No newline at end of file
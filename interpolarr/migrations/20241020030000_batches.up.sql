@@ -0,0 +1,9 @@
+CREATE TABLE batches (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    paused BOOLEAN NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    started_at INTEGER
+);
+ALTER TABLE videos
+ADD batch_id INTEGER REFERENCES batches(id);
\ No newline at end of file
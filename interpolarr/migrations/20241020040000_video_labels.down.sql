@@ -0,0 +1 @@
+ALTER TABLE videos DROP COLUMN labels;
\ No newline at end of file
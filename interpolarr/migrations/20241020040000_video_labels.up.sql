@@ -0,0 +1,2 @@
+ALTER TABLE videos
+ADD labels TEXT;
\ No newline at end of file
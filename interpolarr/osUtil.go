@@ -48,12 +48,25 @@ func PathExist(f string) (bool, error) {
 	if _, err = os.Stat(f); errors.Is(err, os.ErrNotExist) {
 		return false, nil
 	} else if err != nil {
-		return false, nil
+		return false, err
 	}
 
 	return true, err
 }
 
+// Rename the file, copying it when it's on another device
+func MoveFile(src string, dest string) error {
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+
+	if err := CopyFile(src, dest); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
 func RenameOverwrite(src string, dest string) error {
 	_ = os.Remove(dest)
 	return os.Rename(src, dest)
@@ -2,87 +2,587 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
 
-// TODO: make this a configurable setting
-var retryLimit int = 5
-
 type PoolWorker struct {
-	ctx         context.Context
-	queue       *Queue
-	config      *Config
-	waitGroup   sync.WaitGroup
-	workChannel chan Video
-	workers     []*Worker
+	ctx       context.Context
+	queue     *Queue
+	config    *Config
+	waitGroup sync.WaitGroup
+	// Idle workers asking for a video
+	workRequests chan *Worker
+	// Signaled when a paused state changes
+	changed chan struct{}
+	workers []*Worker
+	// Held while handing out videos, the sweep holds it so
+	// no video starts while its files are removed
+	dispatchLock sync.Mutex
+	// Videos handed out and not done yet by id, chunks share the id
+	claimed map[int64]int
+
+	chunkedJobs     map[int64]*ChunkedJob
+	chunkedJobsLock sync.Mutex
+
+	hub    *Hub
+	paused bool
+	// Batches whose videos are not dispatched
+	pausedBatches map[int64]bool
+	// Updated by the dispatcher when a window starts or ends
+	schedule ScheduleInfo
+	sync.RWMutex
+}
+
+const dispatcherPausedKey = "dispatcher_paused"
+
+func workerPausedKey(id int) string {
+	return fmt.Sprintf("worker%d_paused", id)
+}
+
+func getPausedState(key string) (bool, error) {
+	value, ok, err := sqlite.GetState(key)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return strconv.ParseBool(value)
+}
+
+type DispatcherInfo struct {
+	Paused   bool         `json:"paused"`
+	Schedule ScheduleInfo `json:"schedule"`
 }
 
 // TODO: add process output in this
 type ProcessVideoOutput struct {
 	skip                   bool
+	skipReason             string
 	outputFileAlreadyExist bool
-	videoNotFound          bool
+	chunked                bool
 	err                    error
 }
 
 func NewPoolWorker(ctx context.Context, queue *Queue,
 	config *Config, hub *Hub) *PoolWorker {
 	poolWorker := PoolWorker{
-		ctx:         ctx,
-		queue:       queue,
-		config:      config,
-		waitGroup:   sync.WaitGroup{},
-		workChannel: make(chan Video),
-		workers:     nil,
+		ctx:           ctx,
+		queue:         queue,
+		config:        config,
+		waitGroup:     sync.WaitGroup{},
+		workRequests:  make(chan *Worker),
+		changed:       make(chan struct{}, 1),
+		workers:       nil,
+		claimed:       make(map[int64]int),
+		chunkedJobs:   make(map[int64]*ChunkedJob),
+		hub:           hub,
+		pausedBatches: make(map[int64]bool),
 	}
 
+	paused, err := getPausedState(dispatcherPausedKey)
+	if err != nil {
+		log.Panic("Couldn't get dispatcher paused state: ", err)
+	}
+
+	poolWorker.paused = paused
+	batches, err := sqlite.GetBatches()
+	if err != nil {
+		log.Panic("Couldn't get batches paused state: ", err)
+	}
+
+	for _, batch := range batches {
+		if batch.Paused {
+			poolWorker.pausedBatches[batch.ID] = true
+		}
+	}
+
+	poolWorker.schedule, _ = config.Schedule.State(time.Now())
+
 	workers := make([]*Worker, config.Workers)
 	for i := 0; i < config.Workers; i++ {
-		// Setup Worker Logger
-		logger, err := CreateLogger(fmt.Sprintf("worker%d", i))
+		workers[i], err = poolWorker.newWorker(i)
 		if err != nil {
-			log.Panicf("Couldn't create logger for worker: %d", i)
+			log.Panicf("Couldn't create worker %d: %v", i, err)
 		}
-
-		workers[i] = NewWorker(i, logger, &poolWorker, hub)
 	}
 
 	poolWorker.workers = workers
 	return &poolWorker
 }
 
+// Create a worker with its own logger and paused state
+func (p *PoolWorker) newWorker(id int) (*Worker, error) {
+	logger, err := CreateLogger(fmt.Sprintf("worker%d", id))
+	if err != nil {
+		return nil, err
+	}
+
+	worker := NewWorker(id, logger, p, p.hub)
+	paused, err := getPausedState(workerPausedKey(id))
+	if err != nil {
+		return nil, err
+	}
+
+	worker.workerInfo.Paused = paused
+	return worker, nil
+}
+
 func (p *PoolWorker) RunDispatcherBlocking() {
-	for i := 0; i < p.config.Workers; i++ {
-		go p.workers[i].start()
+	for _, worker := range p.Workers() {
+		go worker.start()
 	}
 
+	idle := []*Worker{}
+	scheduleChange := p.updateSchedule()
 	for {
+		idle = p.dispatch(idle)
+
+		// Wake up when a retried video can be processed
+		var retryReady <-chan time.Time
+		if next, ok := p.queue.NextNotBefore(time.Now()); ok && len(idle) > 0 {
+			retryReady = time.After(time.Until(next))
+		}
+
+		// Wait for something that could allow dispatching
 		select {
 		case <-p.ctx.Done():
 			return
-		default:
-			video, ok := p.queue.Peek()
-			if ok {
-				select {
-				case p.workChannel <- video:
-					p.queue.Dequeue()
-				default:
-					time.Sleep(100 * time.Millisecond)
-				}
-			} else {
-				time.Sleep(100 * time.Millisecond)
+		case worker := <-p.workRequests:
+			idle = append(idle, worker)
+		case <-p.queue.Changed():
+		case <-p.changed:
+		case <-scheduleChange:
+			scheduleChange = p.updateSchedule()
+		case <-retryReady:
+		}
+	}
+}
+
+// Refresh the schedule state, running videos are paused if the window
+// that ended asks for it. Returns a channel receiving on the next change
+func (p *PoolWorker) updateSchedule() <-chan time.Time {
+	info, _ := p.config.Schedule.State(time.Now())
+	p.Lock()
+	previous := p.schedule
+	p.schedule = info
+	p.Unlock()
+
+	if previous.InWindow && !info.InWindow {
+		log.WithField("window", previous.Window).Info("Schedule window ended")
+		if previous.WindowOnEnd == WindowEndPause {
+			p.suspendRunning()
+		}
+	} else if !previous.InWindow && info.InWindow {
+		log.WithField("window", info.Window).Info("Schedule window started")
+	}
+
+	p.sendUpdate()
+	next, ok := info.NextChange()
+	if !ok {
+		return nil
+	}
+
+	return time.After(time.Until(next))
+}
+
+// Stop the running videos and put them back in the queue,
+// videos that are run now are kept running
+func (p *PoolWorker) suspendRunning() {
+	for _, worker := range p.Workers() {
+		worker.SuspendCurrent()
+	}
+}
+
+func (p *PoolWorker) InWindow() bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.schedule.InWindow
+}
+
+// Give a video to every idle worker that can take one, the video is
+// removed from the queue at the same time it's given to the worker so
+// it can't be removed or given twice in between. Returns the workers
+// that are still idle
+func (p *PoolWorker) dispatch(idle []*Worker) []*Worker {
+	p.dispatchLock.Lock()
+	defer p.dispatchLock.Unlock()
+
+	paused := p.IsPaused()
+	pausedBatches := p.PausedBatches()
+	remaining := []*Worker{}
+	for _, worker := range idle {
+		if p.removeIfDraining(worker) {
+			// Let the worker exit
+			close(worker.assign)
+			continue
+		}
+
+		if paused || worker.IsPaused() {
+			remaining = append(remaining, worker)
+			continue
+		}
+
+		now := time.Now()
+		inWindow := p.InWindow()
+		video, ok := p.queue.DequeueFirst(func(video *Video) bool {
+			if video.NotBefore != nil && video.NotBefore.After(now) {
+				return false
+			}
+
+			if pausedBatches[video.BatchID] {
+				return false
 			}
+
+			// Outside of the schedule only the videos run now are processed
+			return inWindow || video.RunNow
+		})
+
+		if !ok {
+			remaining = append(remaining, worker)
+			continue
 		}
+
+		// The worker is waiting on it, this never blocks
+		p.claim(video.ID)
+		worker.assign <- video
+	}
+
+	return remaining
+}
+
+// The video is handed out, it's running until released
+func (p *PoolWorker) claim(id int64) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.claimed[id]++
+}
+
+// The worker is done with the video and its files
+func (p *PoolWorker) release(id int64) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.claimed[id]--
+	if p.claimed[id] <= 0 {
+		delete(p.claimed, id)
+	}
+}
+
+// Wake up the dispatcher
+func (p *PoolWorker) signalChanged() {
+	select {
+	case p.changed <- struct{}{}:
+	default:
 	}
 }
 
+func (p *PoolWorker) IsPaused() bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.paused
+}
+
+// Stop dispatching new videos, the running ones are still finished
+func (p *PoolWorker) SetPaused(paused bool) error {
+	err := sqlite.SetState(dispatcherPausedKey, strconv.FormatBool(paused))
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	p.paused = paused
+	p.Unlock()
+	p.signalChanged()
+	p.sendUpdate()
+	return nil
+}
+
+func (p *PoolWorker) SetWorkerPaused(id int, paused bool, cancel bool) (WorkerInfo, error) {
+	worker, ok := p.GetWorker(id)
+	if !ok {
+		return WorkerInfo{}, fmt.Errorf("worker %d not found", id)
+	}
+
+	err := sqlite.SetState(workerPausedKey(id), strconv.FormatBool(paused))
+	if err != nil {
+		return WorkerInfo{}, err
+	}
+
+	worker.SetPaused(paused)
+	p.signalChanged()
+	if paused && cancel {
+		worker.CancelCurrent()
+	}
+
+	return worker.GetInfo(), nil
+}
+
+// Scale the workers up or down, the extra workers finish their current
+// video before exiting. Workers still draining are used again first
+func (p *PoolWorker) SetWorkerCount(count int) error {
+	if count < 1 {
+		return errors.New("at least one worker is needed")
+	}
+
+	p.Lock()
+	active := []*Worker{}
+	draining := []*Worker{}
+	for _, worker := range p.workers {
+		if worker.IsDraining() {
+			draining = append(draining, worker)
+		} else {
+			active = append(active, worker)
+		}
+	}
+
+	// The last workers are the ones removed
+	for i := count; i < len(active); i++ {
+		active[i].SetDraining(true)
+	}
+
+	total := len(active)
+	for _, worker := range draining {
+		if total >= count {
+			break
+		}
+
+		worker.SetDraining(false)
+		total++
+	}
+
+	started := []*Worker{}
+	for id := 0; total < count; id++ {
+		if _, ok := p.getWorkerInternal(id); ok {
+			continue
+		}
+
+		worker, err := p.newWorker(id)
+		if err != nil {
+			p.Unlock()
+			return err
+		}
+
+		p.workers = append(p.workers, worker)
+		started = append(started, worker)
+		total++
+	}
+
+	sort.Slice(p.workers, func(i, j int) bool {
+		return p.workers[i].workerInfo.ID < p.workers[j].workerInfo.ID
+	})
+	p.Unlock()
+
+	for _, worker := range started {
+		worker.logger.Info("Starting worker")
+		go worker.start()
+	}
+
+	// Idle workers that are draining are stopped by the dispatcher
+	p.signalChanged()
+	p.sendWorkersUpdate()
+	return nil
+}
+
+// Remove the worker if it's draining, returns true when it was removed
+// and should exit
+func (p *PoolWorker) removeIfDraining(worker *Worker) bool {
+	p.Lock()
+	if !worker.IsDraining() {
+		p.Unlock()
+		return false
+	}
+
+	for i, w := range p.workers {
+		if w == worker {
+			p.workers = append(p.workers[:i], p.workers[i+1:]...)
+			break
+		}
+	}
+
+	p.Unlock()
+	worker.logger.Info("Worker drained, stopping")
+	p.sendWorkersUpdate()
+	return true
+}
+
+// Replace the labels of a video being processed, on every worker
+// running one of its chunks
+func (p *PoolWorker) SetVideoLabels(id int64, labels []string) {
+	for _, worker := range p.Workers() {
+		worker.SetVideoLabels(id, labels)
+	}
+}
+
+// Videos being processed, a video split into chunks is running
+// until every chunk is done
+func (p *PoolWorker) RunningVideos() []Video {
+	videos := []Video{}
+	for _, info := range p.GetWorkerInfos() {
+		if info.Video != nil {
+			videos = append(videos, *info.Video)
+		}
+	}
+
+	p.chunkedJobsLock.Lock()
+	defer p.chunkedJobsLock.Unlock()
+
+	for _, job := range p.chunkedJobs {
+		videos = append(videos, job.video)
+	}
+
+	return videos
+}
+
+func (p *PoolWorker) Workers() []*Worker {
+	p.RLock()
+	defer p.RUnlock()
+
+	return append([]*Worker{}, p.workers...)
+}
+
+func (p *PoolWorker) GetWorker(id int) (*Worker, bool) {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.getWorkerInternal(id)
+}
+
+func (p *PoolWorker) getWorkerInternal(id int) (*Worker, bool) {
+	for _, worker := range p.workers {
+		if worker.workerInfo.ID == id {
+			return worker, true
+		}
+	}
+
+	return nil, false
+}
+
+// Cancel the video on every worker processing it (a chunked video
+// can be on multiple workers), returns true if it was running. A video
+// split into chunks is cancelled here when none of its chunks is running
+func (p *PoolWorker) CancelVideo(id int64) bool {
+	// Remaining chunks won't be needed, removed first so they aren't dispatched
+	job, chunked := p.GetChunkedJob(id)
+	if chunked {
+		p.queue.RemoveAllByID(id)
+	}
+
+	cancelled := false
+	for _, worker := range p.Workers() {
+		if worker.CancelVideo(id) {
+			cancelled = true
+		}
+	}
+
+	if !chunked {
+		return cancelled
+	}
+
+	if !cancelled {
+		// No worker cleans up after it
+		video := job.video
+		log.WithField("id", id).Info("Canceling chunked video with no chunk running")
+		p.RemoveChunkedJob(id)
+		job.RemoveParts()
+		if err := ClearCheckpoints(&video); err != nil {
+			log.WithField("id", id).Error("Failed to clear checkpoints: ", err)
+		}
+
+		if err := sqlite.CancelVideo(&video); err != nil {
+			log.WithField("id", id).Error("Failed to mark video as canceled: ", err)
+		}
+
+		sendBatchUpdate(video.BatchID, true)
+	}
+
+	return true
+}
+
+func (p *PoolWorker) GetDispatcherInfo() DispatcherInfo {
+	p.RLock()
+	defer p.RUnlock()
+
+	return DispatcherInfo{
+		Paused:   p.paused,
+		Schedule: p.schedule,
+	}
+}
+
+func (p *PoolWorker) sendUpdate() {
+	packet := WsDispatcherUpdate{
+		WsBaseMessage: WsBaseMessage{
+			Type: "dispatcher_update",
+		},
+		DispatcherInfo: p.GetDispatcherInfo(),
+	}
+
+	p.hub.BroadcastMessage(packet)
+}
+
 func (p *PoolWorker) GetWorkerInfos() []WorkerInfo {
 	var info []WorkerInfo
-	for _, worker := range p.workers {
+	for _, worker := range p.Workers() {
 		info = append(info, worker.GetInfo())
 	}
 
 	return info
 }
+
+// Send every worker, sent when workers are added or removed
+func (p *PoolWorker) sendWorkersUpdate() {
+	packet := WsWorkersUpdate{
+		WsBaseMessage: WsBaseMessage{
+			Type: "workers_update",
+		},
+		Workers: p.GetWorkerInfos(),
+	}
+
+	p.hub.BroadcastMessage(packet)
+}
+
+func (p *PoolWorker) ShouldChunk(videoInfo *VideoInfo) bool {
+	chunking := p.config.Chunking
+	return *chunking.Enabled && chunking.Chunks > 1 &&
+		videoInfo.Duration >= chunking.MinDuration
+}
+
+func (p *PoolWorker) AddChunkedJob(job *ChunkedJob) {
+	p.chunkedJobsLock.Lock()
+	defer p.chunkedJobsLock.Unlock()
+
+	p.chunkedJobs[job.video.ID] = job
+}
+
+func (p *PoolWorker) GetChunkedJob(id int64) (*ChunkedJob, bool) {
+	p.chunkedJobsLock.Lock()
+	defer p.chunkedJobsLock.Unlock()
+
+	job, ok := p.chunkedJobs[id]
+	return job, ok
+}
+
+// Remove every chunked job and their parts, the chunks
+// progress isn't kept so the videos are split again
+func (p *PoolWorker) RemoveChunkedJobs() {
+	p.chunkedJobsLock.Lock()
+	defer p.chunkedJobsLock.Unlock()
+
+	for id, job := range p.chunkedJobs {
+		job.RemoveParts()
+		delete(p.chunkedJobs, id)
+	}
+}
+
+func (p *PoolWorker) RemoveChunkedJob(id int64) {
+	p.chunkedJobsLock.Lock()
+	defer p.chunkedJobsLock.Unlock()
+
+	delete(p.chunkedJobs, id)
+}
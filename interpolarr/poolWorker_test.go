@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A pool worker with no database and no running workers, the
+// dispatcher is started and stopped with the test
+func newTestPoolWorker(t *testing.T, queue *Queue) *PoolWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduleEnabled := false
+	config := &Config{}
+	config.Schedule.Enabled = &scheduleEnabled
+
+	poolWorker := &PoolWorker{
+		ctx:           ctx,
+		queue:         queue,
+		config:        config,
+		workRequests:  make(chan *Worker),
+		changed:       make(chan struct{}, 1),
+		claimed:       make(map[int64]int),
+		chunkedJobs:   make(map[int64]*ChunkedJob),
+		hub:           queue.hub,
+		pausedBatches: make(map[int64]bool),
+	}
+
+	poolWorker.schedule, _ = config.Schedule.State(time.Now())
+
+	done := make(chan struct{})
+	go func() {
+		poolWorker.RunDispatcherBlocking()
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return poolWorker
+}
+
+// Ask the dispatcher for videos like an idle worker, run is called
+// with every video given to the worker
+func runTestWorker(poolWorker *PoolWorker, id int, run func(video Video)) {
+	logger := logrus.NewEntry(logrus.New())
+	worker := NewWorker(id, logger, poolWorker, poolWorker.hub)
+	for {
+		select {
+		case <-poolWorker.ctx.Done():
+			return
+		case poolWorker.workRequests <- worker:
+		}
+
+		select {
+		case <-poolWorker.ctx.Done():
+			return
+		case video, ok := <-worker.assign:
+			if !ok {
+				return
+			}
+
+			run(video)
+		}
+	}
+}
+
+func TestPoolWorkerDispatchConcurrent(t *testing.T) {
+	const producers = 8
+	const perProducer = 50
+	const total = producers * perProducer
+
+	queue := newTestQueue(t)
+	poolWorker := newTestPoolWorker(t, queue)
+	handed := newHandedOut()
+	requeued := sync.Map{}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// Workers put some videos back once, like a suspended video,
+	// they stop with the dispatcher
+	for id := 0; id < 8; id++ {
+		go runTestWorker(poolWorker, id, func(video Video) {
+			if _, loaded := requeued.LoadOrStore(video.ID, true); !loaded && video.ID%5 == 0 {
+				queue.Requeue(video)
+				return
+			}
+
+			handed.add(video.ID)
+		})
+	}
+
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				id := int64(p*perProducer + i + 1)
+				queue.Enqueue(Video{ID: id, Priority: int(id % 3)})
+			}
+		}(p)
+	}
+
+	// Deleted from the queue while the dispatcher hands them out
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				for id := int64(r + 1); id <= total; id += 4 {
+					if _, ok := queue.RemoveByID(id); ok {
+						handed.add(id)
+					}
+				}
+			}
+		}(r)
+	}
+
+	handed.wait(t, total)
+	close(stop)
+	wg.Wait()
+	handed.check(t, total)
+	if videos := queue.GetVideos(); len(videos) != 0 {
+		t.Errorf("%d videos left in the queue", len(videos))
+	}
+}
+
+func TestPoolWorkerDispatchPausedBatch(t *testing.T) {
+	queue := newTestQueue(t)
+	poolWorker := newTestPoolWorker(t, queue)
+	poolWorker.Lock()
+	poolWorker.pausedBatches[1] = true
+	poolWorker.Unlock()
+	poolWorker.signalChanged()
+
+	queue.Enqueue(Video{ID: 1, BatchID: 1})
+	queue.Enqueue(Video{ID: 2, BatchID: 2})
+
+	given := make(chan Video)
+	go runTestWorker(poolWorker, 0, func(video Video) {
+		given <- video
+	})
+
+	if video := <-given; video.ID != 2 {
+		t.Fatalf("video %d of a paused batch was given", video.ID)
+	}
+
+	if _, index := queue.FindByID(1); index == -1 {
+		t.Fatal("video of the paused batch left the queue")
+	}
+}
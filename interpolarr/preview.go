@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxPreviewDuration = 60.0
+
+type PreviewRequest struct {
+	Path string `json:"path" binding:"required"`
+	// Start of the preview in seconds
+	Timestamp float64 `json:"timestamp"`
+	// Duration of the preview in seconds
+	Duration float64 `json:"duration"`
+	// Optional settings, resolved like the settings of a video
+	Settings VideoSettings `json:"settings"`
+	// Selected by the rules when not set
+	Profile string `json:"profile"`
+	// Shortcuts overriding the settings
+	TargetFPS float64 `json:"targetFPS"`
+	ModelPath string  `json:"modelPath"`
+}
+
+type Preview struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Profile the preview was interpolated with
+	Profile string `json:"profile,omitempty"`
+	Path    string `json:"-"`
+}
+
+// Generate short interpolated clips using the same
+// pipeline as the workers, only one at a time
+type Previewer struct {
+	dir    string
+	ttl    time.Duration
+	worker *Worker
+	sync.Mutex
+}
+
+func NewPreviewer(config *Config, poolWorker *PoolWorker) (*Previewer, error) {
+	err := os.MkdirAll(config.Preview.Path, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	logger, err := CreateLogger("preview")
+	if err != nil {
+		return nil, err
+	}
+
+	// Not a worker of the pool, without a hub its progress isn't sent
+	return &Previewer{
+		dir:    config.Preview.Path,
+		ttl:    time.Duration(config.Preview.TTL) * time.Minute,
+		worker: NewWorker(-1, logger, poolWorker, nil),
+	}, nil
+}
+
+func newPreviewID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// The video the preview is interpolated like, with its profile selected
+func (r *PreviewRequest) video(config *Config) (Video, error) {
+	video := Video{
+		Path:     r.Path,
+		Settings: r.Settings,
+		Profile:  r.Profile,
+	}
+
+	if r.TargetFPS != 0 {
+		video.Settings.TargetFPS = r.TargetFPS
+	}
+
+	if r.ModelPath != "" {
+		video.Settings.ModelPath = r.ModelPath
+	}
+
+	if err := video.Settings.verify(); err != nil {
+		return Video{}, err
+	}
+
+	err := applyProfile(&video, config)
+	return video, err
+}
+
+// Generate the preview, stopped when ctx is done
+func (p *Previewer) Generate(ctx context.Context, request *PreviewRequest) (*Preview, string, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	w := p.worker
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Also stopped when shutting down
+	stop := context.AfterFunc(w.poolWorker.ctx, cancel)
+	defer stop()
+
+	w.Lock()
+	w.jobCtx = ctx
+	w.cancelJob = cancel
+	w.Unlock()
+	defer func() {
+		w.Lock()
+		w.jobCtx = nil
+		w.cancelJob = nil
+		w.Unlock()
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	w.logger.WithFields(StructFields(request)).Info("Generating preview")
+	video, err := request.video(w.poolWorker.config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	settings := w.settings(&video)
+	videoInfo, output, err := GetVideoInfo(w.ctx(), request.Path)
+	if err != nil {
+		return nil, output, err
+	}
+
+	if request.Timestamp < 0 || request.Timestamp >= videoInfo.Duration {
+		return nil, "", fmt.Errorf("timestamp is outside of the video (duration %.2fs)", videoInfo.Duration)
+	}
+
+	duration := request.Duration
+	if duration <= 0 {
+		duration = 5
+	}
+
+	duration = min(duration, maxPreviewDuration, videoInfo.Duration-request.Timestamp)
+	segment := VideoSegment{Start: request.Timestamp, Duration: duration}
+	segmentInfo := videoInfo.SegmentInfo(segment)
+	if segmentInfo.FrameCount < 2 {
+		return nil, "", errors.New("preview is too short")
+	}
+
+	preview, err := p.newPreview(".mp4")
+	if err != nil {
+		return nil, "", err
+	}
+
+	preview.Profile = video.Profile
+	outputPath := preview.Path
+	progressChan := make(chan float64)
+	go w.updateProgress(progressChan)
+	err = w.interpolate(&Interpolation{
+		VideoInfo:     &segmentInfo,
+		Segment:       &segment,
+		OutputPath:    outputPath,
+		SegmentAudio:  true,
+		TargetFPS:     settings.TargetFPS,
+		ModelPath:     settings.ModelPath,
+		Rife:          settings.Rife,
+		FFmpegOptions: settings.FFmpegOptions,
+	}, progressChan)
+	close(progressChan)
+	if err != nil {
+		_ = os.Remove(outputPath)
+		return nil, "", err
+	}
+
+	w.logger.WithField("id", preview.ID).Info("Preview generated")
+	return preview, "", nil
+}
+
+// Reserve a new file in the preview folder, it will be served
+// and removed like any other preview
+func (p *Previewer) newPreview(ext string) (*Preview, error) {
+	id, err := newPreviewID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Preview{
+		ID:        id,
+		URL:       "/api/preview/" + id,
+		ExpiresAt: time.Now().Add(p.ttl),
+		Path:      filepath.Join(p.dir, id+ext),
+	}, nil
+}
+
+func (p *Previewer) GetPath(id string) (string, bool) {
+	if id == "" || filepath.Base(id) != id {
+		return "", false
+	}
+
+	matches, err := filepath.Glob(filepath.Join(p.dir, id+".*"))
+	if err != nil || len(matches) == 0 {
+		return "", false
+	}
+
+	return matches[0], true
+}
+
+// Delete expired previews until the context is done
+func (p *Previewer) RunCleanupBlocking(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.removeExpired()
+		}
+	}
+}
+
+func (p *Previewer) removeExpired() {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		p.worker.logger.Error("Failed to read preview folder: ", err)
+		return
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+
+		if time.Since(info.ModTime()) < p.ttl {
+			continue
+		}
+
+		previewPath := filepath.Join(p.dir, entry.Name())
+		if err := os.Remove(previewPath); err != nil {
+			p.worker.logger.Error("Failed to remove expired preview: ", err)
+			continue
+		}
+
+		p.worker.logger.WithField("file", previewPath).Debug("Removed expired preview")
+	}
+}
+
+func createPreview(c *gin.Context) {
+	var request PreviewRequest
+	if err := c.ShouldBind(&request); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	videoExist, err := PathExist(request.Path)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	if !videoExist {
+		c.String(400, "video source not found")
+		return
+	}
+
+	preview, output, err := previewer.Generate(c.Request.Context(), &request)
+	if err != nil {
+		log.WithFields(StructFields(request)).Error("Error generating preview: ", err)
+		if output != "" {
+			log.Debug("Process ouput: ", output)
+		}
+
+		c.String(400, err.Error())
+		return
+	}
+
+	c.JSON(200, preview)
+}
+
+func getPreview(c *gin.Context) {
+	previewPath, ok := previewer.GetPath(c.Param("id"))
+	if !ok {
+		c.String(http.StatusNotFound, "preview not found")
+		return
+	}
+
+	c.File(previewPath)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// How long probing a video for the rules can take
+const ruleProbeTimeout = time.Minute
+
+// Settings bundled under a name
+type Profile struct {
+	// interpolate or validate
+	Mode     string        `yaml:"mode" json:"mode,omitempty"`
+	Settings VideoSettings `yaml:",inline" json:"settings"`
+}
+
+// Selects the profile of the videos matching every condition set
+type ProfileRule struct {
+	// Recorded on the videos matching the rule, its index when not set
+	Name    string `yaml:"name"`
+	Profile string `yaml:"profile"`
+	// Regex matched on the path of the video
+	Path      string  `yaml:"path"`
+	MinWidth  int     `yaml:"minWidth"`
+	MaxWidth  int     `yaml:"maxWidth"`
+	MinHeight int     `yaml:"minHeight"`
+	MaxHeight int     `yaml:"maxHeight"`
+	MinFPS    float64 `yaml:"minFPS"`
+	MaxFPS    float64 `yaml:"maxFPS"`
+	// Codec names given by ffprobe, like h264 or hevc
+	Codecs []string `yaml:"codecs"`
+	// The video has one of the labels
+	Labels []string `yaml:"labels"`
+	// Seconds
+	MinDuration float64 `yaml:"minDuration"`
+	MaxDuration float64 `yaml:"maxDuration"`
+	path        *regexp.Regexp
+}
+
+func verifyProfiles(config *Config) error {
+	for name, profile := range config.Profiles {
+		if profile.Mode != "" && profile.Mode != JobModeInterpolate && profile.Mode != JobModeValidate {
+			return fmt.Errorf("profile %s: unknown mode: %s", name, profile.Mode)
+		}
+
+		if err := profile.Settings.verify(); err != nil {
+			return fmt.Errorf("profile %s: %v", name, err)
+		}
+	}
+
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprint(i)
+		}
+
+		if _, ok := config.Profiles[rule.Profile]; !ok {
+			return fmt.Errorf("rule %s: unknown profile: %s", rule.Name, rule.Profile)
+		}
+
+		if rule.Path != "" {
+			path, err := regexp.Compile(rule.Path)
+			if err != nil {
+				return fmt.Errorf("rule %s: %v", rule.Name, err)
+			}
+
+			rule.path = path
+		}
+	}
+
+	if _, ok := config.Profiles[config.DefaultProfile]; config.DefaultProfile != "" && !ok {
+		return fmt.Errorf("unknown default profile: %s", config.DefaultProfile)
+	}
+
+	return nil
+}
+
+// The rule needs the info of the video
+func (r *ProfileRule) needsProbe() bool {
+	return r.MinWidth != 0 || r.MaxWidth != 0 || r.MinHeight != 0 || r.MaxHeight != 0 ||
+		r.MinFPS != 0 || r.MaxFPS != 0 || len(r.Codecs) > 0 ||
+		r.MinDuration != 0 || r.MaxDuration != 0
+}
+
+// A max of 0 is no limit
+func inRange[T int | float64](value T, minValue T, maxValue T) bool {
+	return value >= minValue && (maxValue == 0 || value <= maxValue)
+}
+
+// The conditions that don't need the info of the video
+func (r *ProfileRule) matchVideo(video *Video) bool {
+	if r.path != nil && !r.path.MatchString(video.Path) {
+		return false
+	}
+
+	return video.HasAnyLabel(r.Labels)
+}
+
+// videoInfo is nil when the video couldn't be probed
+func (r *ProfileRule) Match(video *Video, videoInfo *VideoInfo) bool {
+	if !r.matchVideo(video) {
+		return false
+	}
+
+	if !r.needsProbe() {
+		return true
+	}
+
+	if videoInfo == nil {
+		return false
+	}
+
+	if len(r.Codecs) > 0 && !containsFold(r.Codecs, videoInfo.Codec) {
+		return false
+	}
+
+	return inRange(videoInfo.Width, r.MinWidth, r.MaxWidth) &&
+		inRange(videoInfo.Height, r.MinHeight, r.MaxHeight) &&
+		inRange(videoInfo.FrameRate, r.MinFPS, r.MaxFPS) &&
+		inRange(videoInfo.Duration, r.MinDuration, r.MaxDuration)
+}
+
+// Set the profile of the video from the first rule matching, or the
+// default profile. A video that already has a profile keeps it
+func applyProfile(video *Video, config *Config) error {
+	if video.Profile != "" {
+		if _, ok := config.Profiles[video.Profile]; !ok {
+			return fmt.Errorf("unknown profile: %s", video.Profile)
+		}
+
+		return nil
+	}
+
+	// Only probed once a rule needs it
+	var videoInfo *VideoInfo
+	probed := false
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if !rule.matchVideo(video) {
+			continue
+		}
+
+		if rule.needsProbe() && !probed {
+			probed = true
+			ctx, cancel := context.WithTimeout(context.Background(), ruleProbeTimeout)
+			info, _, err := ProbeVideo(ctx, video.Path)
+			cancel()
+			if err != nil {
+				log.WithFields(StructFields(video)).Warn("Failed to probe the video for the rules: ", err)
+			} else {
+				videoInfo = info
+			}
+		}
+
+		if rule.Match(video, videoInfo) {
+			log.WithFields(StructFields(video)).
+				WithField("rule", rule.Name).
+				WithField("profile", rule.Profile).
+				Debug("Rule matched")
+			video.Profile = rule.Profile
+			video.Rule = rule.Name
+			return nil
+		}
+	}
+
+	video.Profile = config.DefaultProfile
+	return nil
+}
@@ -2,61 +2,231 @@ package main
 
 import (
 	"sync"
+	"time"
 )
 
 type Queue struct {
 	videos []Video
 	hub    *Hub
 	lock   sync.Mutex
+	// Signaled when the queue changes
+	changed chan struct{}
 }
 
 func NewQueue(videos []Video, hub *Hub) (Queue, error) {
 	return Queue{
-		videos: videos,
-		hub:    hub,
+		videos:  videos,
+		hub:     hub,
+		changed: make(chan struct{}, 1),
 	}, nil
 }
 
+// Receives when the queue changed since the last receive
+func (q *Queue) Changed() <-chan struct{} {
+	return q.changed
+}
+
 func (q *Queue) GetVideos() []Video {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
-	return q.videos
+	return append([]Video{}, q.videos...)
 }
 
+// Add the video at the end of its priority, the queue is
+// ordered by priority and then first in first out
 func (q *Queue) Enqueue(item Video) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
-	q.videos = append(q.videos, item)
+	q.insertInternal(item)
 	q.sendUpdate()
 }
 
-func (q *Queue) Peek() (Video, bool) {
+func (q *Queue) insertInternal(item Video) {
+	index := len(q.videos)
+	for i, video := range q.videos {
+		if video.Priority < item.Priority {
+			index = i
+			break
+		}
+	}
+
+	q.insertAtInternal(item, index)
+}
+
+func (q *Queue) insertAtInternal(item Video, index int) {
+	q.videos = append(q.videos, Video{})
+	copy(q.videos[index+1:], q.videos[index:])
+	q.videos[index] = item
+}
+
+// Move the video to the index, -1 being the end of the queue.
+// The video takes the priority of the video it lands on
+// so the queue stays ordered by priority
+func (q *Queue) Move(id int64, index int) (Video, bool) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
-	if len(q.videos) == 0 {
+	video, current := q.findByIDInternal(id)
+	if current == -1 {
 		return Video{}, false
 	}
 
-	return q.videos[0], true
+	q.videos = append(q.videos[:current], q.videos[current+1:]...)
+	if index < 0 || index > len(q.videos) {
+		index = len(q.videos)
+	}
+
+	if index < len(q.videos) && q.videos[index].Priority > video.Priority {
+		video.Priority = q.videos[index].Priority
+	}
+
+	if index > 0 && q.videos[index-1].Priority < video.Priority {
+		video.Priority = q.videos[index-1].Priority
+	}
+
+	q.insertAtInternal(video, index)
+	q.sendUpdate()
+	return video, true
 }
 
-func (q *Queue) Dequeue() (Video, bool) {
+// Change the priority of the video, it goes to the
+// end of its new priority
+func (q *Queue) SetPriority(id int64, priority int) (Video, bool) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
-	if len(q.videos) == 0 {
+	video, index := q.findByIDInternal(id)
+	if index == -1 {
 		return Video{}, false
 	}
 
-	video := q.videos[0]
-	q.videos = q.videos[1:]
+	q.videos = append(q.videos[:index], q.videos[index+1:]...)
+	video.Priority = priority
+	q.insertInternal(video)
 	q.sendUpdate()
 	return video, true
 }
 
+// Put the videos in front of the videos of their priority,
+// keeping their order
+func (q *Queue) EnqueueFront(items []Video) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// Inserted from the last one so the first one ends up in front
+	for i := len(items) - 1; i >= 0; i-- {
+		q.insertFrontInternal(items[i])
+	}
+
+	q.sendUpdate()
+}
+
+// Put the video back in front of the videos of its priority
+func (q *Queue) Requeue(item Video) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.insertFrontInternal(item)
+	q.sendUpdate()
+}
+
+func (q *Queue) insertFrontInternal(item Video) {
+	index := len(q.videos)
+	for i, video := range q.videos {
+		if video.Priority <= item.Priority {
+			index = i
+			break
+		}
+	}
+
+	q.insertAtInternal(item, index)
+}
+
+func (q *Queue) Dequeue() (Video, bool) {
+	return q.DequeueFirst(nil)
+}
+
+// Remove and return the first video matching, every video
+// matches when match is nil
+func (q *Queue) DequeueFirst(match func(video *Video) bool) (Video, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for i := range q.videos {
+		if match != nil && !match(&q.videos[i]) {
+			continue
+		}
+
+		video := q.videos[i]
+		q.videos = append(q.videos[:i], q.videos[i+1:]...)
+		q.sendUpdate()
+		return video, true
+	}
+
+	return Video{}, false
+}
+
+// Earliest time a video waiting to be retried can be processed
+func (q *Queue) NextNotBefore(now time.Time) (time.Time, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	next := time.Time{}
+	for _, video := range q.videos {
+		if video.NotBefore != nil && video.NotBefore.After(now) &&
+			(next.IsZero() || video.NotBefore.Before(next)) {
+			next = *video.NotBefore
+		}
+	}
+
+	return next, !next.IsZero()
+}
+
+// Set the run now flag of the video and its chunks, returns
+// false if the video isn't in the queue
+func (q *Queue) SetRunNow(id int64, runNow bool) (Video, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	found := false
+	var video Video
+	for i := range q.videos {
+		if q.videos[i].ID == id {
+			q.videos[i].RunNow = runNow
+			if !found {
+				video = q.videos[i]
+				found = true
+			}
+		}
+	}
+
+	if found {
+		q.sendUpdate()
+	}
+
+	return video, found
+}
+
+// Set the labels of the video and its chunks
+func (q *Queue) SetLabels(id int64, labels []string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	found := false
+	for i := range q.videos {
+		if q.videos[i].ID == id {
+			q.videos[i].Labels = labels
+			found = true
+		}
+	}
+
+	if found {
+		q.sendUpdate()
+	}
+}
+
 func (q *Queue) RemoveByID(id int64) (Video, bool) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
@@ -71,6 +241,26 @@ func (q *Queue) RemoveByID(id int64) (Video, bool) {
 	return video, true
 }
 
+// Remove every video with the id, chunks of a video share its id
+func (q *Queue) RemoveAllByID(id int64) []Video {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	removed := []Video{}
+	videos := []Video{}
+	for _, video := range q.videos {
+		if video.ID == id {
+			removed = append(removed, video)
+		} else {
+			videos = append(videos, video)
+		}
+	}
+
+	q.videos = videos
+	q.sendUpdate()
+	return removed
+}
+
 func (q *Queue) FindByID(id int64) (Video, int) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
@@ -99,11 +289,18 @@ func (q *Queue) findByIDInternal(id int64) (Video, int) {
 }
 
 func (q *Queue) sendUpdate() {
+	// Every change goes through here, wake up the dispatcher
+	select {
+	case q.changed <- struct{}{}:
+	default:
+	}
+
 	packet := WsQeueuUpdate{
 		WsBaseMessage: WsBaseMessage{
 			Type: "queue_update",
 		},
-		Videos: q.videos,
+		// The hub writes it after the lock is released
+		Videos: append([]Video{}, q.videos...),
 	}
 
 	q.hub.BroadcastMessage(packet)
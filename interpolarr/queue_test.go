@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A hub that encodes every message like for a client, nothing is
+// connected in the tests
+func newTestHub(t *testing.T) *Hub {
+	hub := &Hub{broadcast: make(chan interface{})}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case message := <-hub.broadcast:
+				if _, err := json.Marshal(message); err != nil {
+					t.Error("Failed to encode message: ", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	t.Cleanup(func() { close(done) })
+	return hub
+}
+
+func newTestQueue(t *testing.T) *Queue {
+	queue, err := NewQueue([]Video{}, newTestHub(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &queue
+}
+
+// Counts how many times each video was handed out
+type handedOut struct {
+	counts map[int64]int
+	total  atomic.Int64
+	sync.Mutex
+}
+
+func newHandedOut() *handedOut {
+	return &handedOut{counts: map[int64]int{}}
+}
+
+func (h *handedOut) add(id int64) {
+	h.Lock()
+	h.counts[id]++
+	h.Unlock()
+	h.total.Add(1)
+}
+
+func (h *handedOut) wait(t *testing.T, total int64) {
+	deadline := time.Now().Add(10 * time.Second)
+	for h.total.Load() < total {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of the %d videos were handed out", h.total.Load(), total)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (h *handedOut) check(t *testing.T, total int64) {
+	h.Lock()
+	defer h.Unlock()
+
+	for id := int64(1); id <= total; id++ {
+		if h.counts[id] != 1 {
+			t.Errorf("video %d was handed out %d times", id, h.counts[id])
+		}
+	}
+
+	if int64(len(h.counts)) != total {
+		t.Errorf("%d videos were handed out, expected %d", len(h.counts), total)
+	}
+}
+
+func checkQueueOrder(t *testing.T, videos []Video) {
+	for i := 1; i < len(videos); i++ {
+		if videos[i-1].Priority < videos[i].Priority {
+			t.Fatalf("video %d (priority %d) is before video %d (priority %d)",
+				videos[i-1].ID, videos[i-1].Priority, videos[i].ID, videos[i].Priority)
+		}
+	}
+}
+
+func TestQueueConcurrentHandOut(t *testing.T) {
+	const producers = 8
+	const perProducer = 50
+	const total = producers * perProducer
+
+	queue := newTestQueue(t)
+	handed := newHandedOut()
+	requeued := sync.Map{}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				id := int64(p*perProducer + i + 1)
+				queue.Enqueue(Video{ID: id, Priority: int(id % 3)})
+			}
+		}(p)
+	}
+
+	// Consumers put some videos back once, like a suspended video
+	for c := 0; c < 8; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				video, ok := queue.DequeueFirst(func(video *Video) bool {
+					return video.ID%2 == 0 || video.Priority > 0
+				})
+				if !ok {
+					runtime.Gosched()
+					continue
+				}
+
+				if _, loaded := requeued.LoadOrStore(video.ID, true); !loaded && video.ID%5 == 0 {
+					queue.Requeue(video)
+					continue
+				}
+
+				handed.add(video.ID)
+			}
+		}()
+	}
+
+	// Removers take the videos the consumers don't match
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				for id := int64(r + 1); id <= total; id += 4 {
+					if video, ok := queue.RemoveByID(id); ok {
+						if video.ID != id {
+							t.Errorf("removed video %d instead of %d", video.ID, id)
+						}
+
+						handed.add(id)
+					}
+				}
+
+				runtime.Gosched()
+			}
+		}(r)
+	}
+
+	handed.wait(t, total)
+	close(stop)
+	wg.Wait()
+
+	handed.check(t, total)
+	if videos := queue.GetVideos(); len(videos) != 0 {
+		t.Errorf("%d videos left in the queue", len(videos))
+	}
+}
+
+func TestQueueConcurrentEnqueueKeepsOrder(t *testing.T) {
+	queue := newTestQueue(t)
+	var wg sync.WaitGroup
+	for p := 0; p < 8; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				id := int64(p*100 + i + 1)
+				switch i % 3 {
+				case 0:
+					queue.Enqueue(Video{ID: id, Priority: i % 4})
+				case 1:
+					queue.Requeue(Video{ID: id, Priority: i % 4})
+				default:
+					queue.EnqueueFront([]Video{{ID: id, Priority: i % 4}})
+				}
+			}
+		}(p)
+	}
+
+	wg.Wait()
+	videos := queue.GetVideos()
+	if len(videos) != 800 {
+		t.Fatalf("%d videos in the queue, expected 800", len(videos))
+	}
+
+	checkQueueOrder(t, videos)
+}
+
+func TestQueueEnqueueFront(t *testing.T) {
+	queue := newTestQueue(t)
+	queue.Enqueue(Video{ID: 1, Priority: 2})
+	queue.Enqueue(Video{ID: 2, Priority: 0})
+	queue.Enqueue(Video{ID: 3, Priority: 0})
+
+	// Chunks go in front of their priority, in their order
+	queue.EnqueueFront([]Video{{ID: 4, Priority: 0}, {ID: 5, Priority: 0}, {ID: 6, Priority: 1}})
+
+	videos := queue.GetVideos()
+	checkQueueOrder(t, videos)
+	expected := []int64{1, 6, 4, 5, 2, 3}
+	for i, id := range expected {
+		if videos[i].ID != id {
+			t.Fatalf("video %d is at %d, expected video %d", videos[i].ID, i, id)
+		}
+	}
+}
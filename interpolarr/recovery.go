@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// What was found and done when reconciling the database with the files
+type RecoveryReport struct {
+	Videos      int      `json:"videos"`
+	Removed     []string `json:"removed"`
+	Quarantined []string `json:"quarantined"`
+	// Checkpoint parts kept to resume their video
+	Kept []string `json:"kept"`
+	// Videos whose checkpoints were cleared because a part was missing
+	ClearedCheckpoints []int64 `json:"clearedCheckpoints"`
+	// Queued videos whose source is missing
+	MissingSources []int64  `json:"missingSources"`
+	Errors         []string `json:"errors"`
+}
+
+// Files written next to the output while processing: the tmp
+// output, the chunk parts and the checkpoint parts
+func processingFilePattern(outputPath string) *regexp.Regexp {
+	ext := filepath.Ext(outputPath)
+	stem := strings.TrimSuffix(filepath.Base(outputPath), ext)
+	return regexp.MustCompile("^" + regexp.QuoteMeta(stem) +
+		`(\.tmp)?(\.(part|ckpt)\d{3})?` + regexp.QuoteMeta(ext) + "$")
+}
+
+type recovery struct {
+	config *Config
+	report RecoveryReport
+	// Listing of the folders already read
+	dirs map[string][]string
+}
+
+func (r *recovery) listDir(dir string) []string {
+	if names, ok := r.dirs[dir]; ok {
+		return names
+	}
+
+	names := []string{}
+	entries, err := os.ReadDir(dir)
+	if err == nil {
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+
+	r.dirs[dir] = names
+	return names
+}
+
+// Processing files of the video that are on disk
+func (r *recovery) processingFiles(video *Video) []string {
+	dir := filepath.Dir(video.OutputPath)
+	pattern := processingFilePattern(video.OutputPath)
+	files := []string{}
+	for _, name := range r.listDir(dir) {
+		// Without any suffix it's the output itself
+		if name == filepath.Base(video.OutputPath) || !pattern.MatchString(name) {
+			continue
+		}
+
+		files = append(files, filepath.Join(dir, name))
+	}
+
+	return files
+}
+
+// Remove the stale file of the video, or move it to the quarantine
+// folder when there is one. Returns true when it was quarantined
+func removeStaleFile(config *Config, video *Video, path string) (bool, error) {
+	quarantinePath := config.Recovery.QuarantinePath
+	if quarantinePath == "" {
+		return false, os.Remove(path)
+	}
+
+	if err := os.MkdirAll(quarantinePath, os.ModePerm); err != nil {
+		return false, err
+	}
+
+	// Prefixed with the video id so files with the same name don't collide
+	dest := filepath.Join(quarantinePath, fmt.Sprintf("%d_%s", video.ID, filepath.Base(path)))
+	return true, MoveFile(path, dest)
+}
+
+func (r *recovery) removeFile(video *Video, path string) {
+	quarantined, err := removeStaleFile(r.config, video, path)
+	if err != nil {
+		r.report.Errors = append(r.report.Errors, err.Error())
+		return
+	}
+
+	if quarantined {
+		r.report.Quarantined = append(r.report.Quarantined, path)
+	} else {
+		r.report.Removed = append(r.report.Removed, path)
+	}
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+
+	return path
+}
+
+// Keep the checkpoint parts of a queued video that can still be resumed
+func (r *recovery) resumableParts(video *Video) map[string]bool {
+	parts := map[string]bool{}
+	checkpoints, err := sqlite.GetCheckpoints(video)
+	if err != nil {
+		r.report.Errors = append(r.report.Errors, err.Error())
+		return parts
+	}
+
+	for _, checkpoint := range checkpoints {
+		partExist, _ := PathExist(checkpoint.PartPath)
+		if !partExist {
+			// Can't be resumed, it starts from the beginning
+			if err := ClearCheckpoints(video); err != nil {
+				r.report.Errors = append(r.report.Errors, err.Error())
+			}
+
+			r.report.ClearedCheckpoints = append(r.report.ClearedCheckpoints, video.ID)
+			return map[string]bool{}
+		}
+
+		parts[absPath(checkpoint.PartPath)] = true
+	}
+
+	return parts
+}
+
+// Reconcile the videos in the database with the files on disk, the stale
+// processing files of videos that are not running are removed or quarantined.
+// running are the ids of the videos being processed
+func RecoverFiles(config *Config, queue *Queue, running map[int64]bool) (RecoveryReport, error) {
+	r := recovery{
+		config: config,
+		report: RecoveryReport{
+			Removed:            []string{},
+			Quarantined:        []string{},
+			Kept:               []string{},
+			ClearedCheckpoints: []int64{},
+			MissingSources:     []int64{},
+			Errors:             []string{},
+		},
+		dirs: map[string][]string{},
+	}
+
+	videos, err := sqlite.GetAllVideos()
+	if err != nil {
+		return r.report, err
+	}
+
+	queued := map[int64]bool{}
+	for _, video := range queue.GetVideos() {
+		queued[video.ID] = true
+	}
+
+	// Videos can share an output, the files of the running videos
+	// and the parts that can be resumed are kept whatever video they
+	// are found for
+	keep := map[string]bool{}
+	for _, video := range videos {
+		if video.OutputPath == "" {
+			continue
+		}
+
+		if running[video.ID] {
+			for _, path := range r.processingFiles(&video) {
+				keep[absPath(path)] = true
+			}
+		} else if queued[video.ID] {
+			sourceExist, _ := PathExist(video.Path)
+			if !sourceExist {
+				r.report.MissingSources = append(r.report.MissingSources, video.ID)
+			}
+
+			for path := range r.resumableParts(&video) {
+				keep[path] = true
+			}
+		}
+	}
+
+	handled := map[string]bool{}
+	for _, video := range videos {
+		if running[video.ID] || video.OutputPath == "" {
+			continue
+		}
+
+		r.report.Videos++
+		for _, path := range r.processingFiles(&video) {
+			if handled[absPath(path)] {
+				continue
+			}
+
+			handled[absPath(path)] = true
+			if keep[absPath(path)] {
+				r.report.Kept = append(r.report.Kept, path)
+				continue
+			}
+
+			r.removeFile(&video, path)
+		}
+	}
+
+	return r.report, nil
+}
+
+// Ids of the videos that have files being written, the videos handed
+// out to a worker and the videos split into chunks
+func (p *PoolWorker) RunningVideoIDs() map[int64]bool {
+	running := map[int64]bool{}
+	p.RLock()
+	for id := range p.claimed {
+		running[id] = true
+	}
+	p.RUnlock()
+
+	p.chunkedJobsLock.Lock()
+	defer p.chunkedJobsLock.Unlock()
+
+	for id := range p.chunkedJobs {
+		running[id] = true
+	}
+
+	return running
+}
+
+func logRecoveryReport(report *RecoveryReport) {
+	log.WithField("videos", report.Videos).
+		WithField("removed", len(report.Removed)).
+		WithField("quarantined", len(report.Quarantined)).
+		WithField("kept", len(report.Kept)).
+		WithField("clearedCheckpoints", len(report.ClearedCheckpoints)).
+		WithField("missingSources", len(report.MissingSources)).
+		WithField("errors", len(report.Errors)).
+		Info("Recovery report")
+
+	for _, path := range report.Removed {
+		log.WithField("file", path).Info("Removed stale file")
+	}
+
+	for _, path := range report.Quarantined {
+		log.WithField("file", path).Info("Quarantined stale file")
+	}
+
+	for _, id := range report.ClearedCheckpoints {
+		log.WithField("id", id).Warn("Checkpoint part missing, the video will start from the beginning")
+	}
+
+	for _, id := range report.MissingSources {
+		log.WithField("id", id).Warn("Source of queued video is missing")
+	}
+
+	for _, err := range report.Errors {
+		log.Error("Recovery error: ", err)
+	}
+}
+
+func sweepStaleFiles(c *gin.Context) {
+	log.Debug("Sweeping stale files")
+	// No video starts during the sweep
+	poolWorker.dispatchLock.Lock()
+	report, err := RecoverFiles(poolWorker.config, &gQueue, poolWorker.RunningVideoIDs())
+	poolWorker.dispatchLock.Unlock()
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	logRecoveryReport(&report)
+	c.JSON(200, report)
+}
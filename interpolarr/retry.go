@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AttemptDone        = "done"
+	AttemptSkipped     = "skipped"
+	AttemptRetry       = "retry"
+	AttemptFailed      = "failed"
+	AttemptCancelled   = "cancelled"
+	AttemptInterrupted = "interrupted"
+)
+
+// An error that will happen again if the video is retried
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Found in the errors or the ffmpeg output when the
+// source can't be processed, whatever the retries. Missing
+// files and permission errors are retried, the source can be
+// on a share that isn't mounted yet or still being copied
+var permanentErrorPatterns = []string{
+	"invalid data found when processing input",
+	"moov atom not found",
+	"no video streams found",
+	"invalid framerate format",
+	"could not find codec parameters",
+	"decoder not found",
+	"unknown decoder",
+	"unsupported codec",
+}
+
+// Permanent errors fail the video right away instead of being retried
+func IsPermanentError(err error, output string) bool {
+	var permanent *PermanentError
+	if errors.As(err, &permanent) {
+		return true
+	}
+
+	text := strings.ToLower(err.Error() + "\n" + output)
+	for _, pattern := range permanentErrorPatterns {
+		if strings.Contains(text, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// How long to wait before the retry, doubled after each retry
+func (o *RetryOptions) Delay(retry int) time.Duration {
+	seconds := o.Backoff * math.Pow(2, float64(max(retry-1, 0)))
+	return time.Duration(min(seconds, o.MaxBackoff) * float64(time.Second))
+}
+
+// One run of a video, or of a chunk of the video
+type VideoAttempt struct {
+	ID        int64     `json:"id"`
+	VideoID   int64     `json:"videoId"`
+	Attempt   int       `json:"attempt"`
+	Chunk     *int      `json:"chunk,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt"`
+	// done, skipped, retry, failed, cancelled or interrupted
+	Result    string     `json:"result"`
+	Error     string     `json:"error"`
+	Permanent bool       `json:"permanent"`
+	RetryAt   *time.Time `json:"retryAt,omitempty"`
+}
+
+// Save the attempt of the current video in its history
+func (w *Worker) recordAttempt(video *Video, result string, attemptErr error, permanent bool) {
+	w.RLock()
+	startedAt := w.startedAt
+	w.RUnlock()
+
+	attempt := VideoAttempt{
+		VideoID:   video.ID,
+		StartedAt: startedAt,
+		EndedAt:   time.Now(),
+		Result:    result,
+		Permanent: permanent,
+		RetryAt:   video.NotBefore,
+	}
+
+	if attemptErr != nil {
+		attempt.Error = attemptErr.Error()
+	}
+
+	if result != AttemptRetry {
+		attempt.RetryAt = nil
+	}
+
+	if video.Chunk != nil {
+		attempt.Chunk = &video.Chunk.Index
+		attempt.Attempt = video.Chunk.Retries + 1
+	} else {
+		retries, err := sqlite.GetVideoRetries(video)
+		if err != nil {
+			w.logger.WithFields(StructFields(video)).Error("Failed to get retries: ", err)
+		}
+
+		attempt.Attempt = retries + 1
+	}
+
+	if err := sqlite.InsertAttempt(&attempt); err != nil {
+		w.logger.WithFields(StructFields(video)).Error("Failed to save attempt: ", err)
+	}
+}
+
+func listVideoAttempts(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	attempts, err := sqlite.GetAttempts(id)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	c.JSON(200, attempts)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Running videos are finished when the window ends
+	WindowEndFinish = "finish"
+	// Running videos are stopped and put back in the queue, they
+	// resume from their last checkpoint in the next window
+	WindowEndPause = "pause"
+
+	// How far to look for the next window start
+	scheduleLookahead = 366 * 24 * time.Hour
+)
+
+// A cron field, the values it matches
+type cronField map[int]bool
+
+// Cron expression with the 5 usual fields: minute hour day month weekday
+type cronSpec struct {
+	minute  cronField
+	hour    cronField
+	day     cronField
+	month   cronField
+	weekday cronField
+	// The day matches if either day or weekday matches when both are set
+	anyDay     bool
+	anyWeekday bool
+}
+
+// Parse a cron field, supports *, lists, ranges and steps (*/5, 1-10/2)
+func parseCronField(field string, minValue int, maxValue int) (cronField, error) {
+	values := cronField{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if rangePart, stepPart, ok := strings.Cut(part, "/"); ok {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in: %s", part)
+			}
+
+			part = rangePart
+		}
+
+		start, end := minValue, maxValue
+		if part != "*" {
+			startPart, endPart, isRange := strings.Cut(part, "-")
+			var err error
+			start, err = strconv.Atoi(startPart)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value in: %s", part)
+			}
+
+			end = start
+			if isRange {
+				end, err = strconv.Atoi(endPart)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value in: %s", part)
+				}
+			} else if step > 1 {
+				// 5/10 means from 5 to the max every 10
+				end = maxValue
+			}
+		}
+
+		if start < minValue || end > maxValue || start > end {
+			return nil, fmt.Errorf("value out of range (%d-%d) in: %s", minValue, maxValue, part)
+		}
+
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+
+	return values, nil
+}
+
+func parseCron(expression string) (*cronSpec, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression needs 5 fields, got %d: %s", len(fields), expression)
+	}
+
+	limits := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	parsed := [5]cronField{}
+	for i, field := range fields {
+		values, err := parseCronField(field, limits[i][0], limits[i][1])
+		if err != nil {
+			return nil, err
+		}
+
+		parsed[i] = values
+	}
+
+	// Sunday is both 0 and 7
+	if parsed[4][7] {
+		parsed[4][0] = true
+	}
+
+	return &cronSpec{
+		minute:     parsed[0],
+		hour:       parsed[1],
+		day:        parsed[2],
+		month:      parsed[3],
+		weekday:    parsed[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+func (c *cronSpec) Match(t time.Time) bool {
+	return c.minute[t.Minute()] && c.hour[t.Hour()] && c.month[int(t.Month())] && c.matchDay(t)
+}
+
+// The day of the month or the day of the week matches
+func (c *cronSpec) matchDay(t time.Time) bool {
+	dayMatch := c.day[t.Day()]
+	weekdayMatch := c.weekday[int(t.Weekday())]
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekdayMatch
+	case c.anyWeekday:
+		return dayMatch
+	default:
+		return dayMatch || weekdayMatch
+	}
+}
+
+// Last start of the window at or before t, looking back at most the window duration
+func (w *ScheduleWindow) lastStart(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	for start := t; t.Sub(start) < w.duration(); start = start.Add(-time.Minute) {
+		if w.cron.Match(start) {
+			return start, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Next start of the window after t, the months, days and hours
+// that don't match are skipped whole
+func (w *ScheduleWindow) nextStart(t time.Time) (time.Time, bool) {
+	start := t.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.Add(scheduleLookahead); start.Before(limit); {
+		year, month, day := start.Date()
+		var next time.Time
+		switch {
+		case !w.cron.month[int(month)]:
+			next = time.Date(year, month+1, 1, 0, 0, 0, 0, start.Location())
+		case !w.cron.matchDay(start):
+			next = time.Date(year, month, day+1, 0, 0, 0, 0, start.Location())
+		case !w.cron.hour[start.Hour()]:
+			next = start.Add(time.Duration(60-start.Minute()) * time.Minute)
+		case !w.cron.minute[start.Minute()]:
+			next = start.Add(time.Minute)
+		default:
+			return start, true
+		}
+
+		// Midnight can be skipped or repeated by a time change
+		if !next.After(start) {
+			next = start.Add(time.Minute)
+		}
+
+		start = next
+	}
+
+	return time.Time{}, false
+}
+
+func (w *ScheduleWindow) duration() time.Duration {
+	return time.Duration(w.Duration) * time.Minute
+}
+
+// Pausing a window needs the checkpoints to resume the videos
+func verifySchedule(schedule *ScheduleOptions, checkpoints bool) error {
+	for i := range schedule.Windows {
+		window := &schedule.Windows[i]
+		cron, err := parseCron(window.Cron)
+		if err != nil {
+			return fmt.Errorf("schedule window %d: %v", i, err)
+		}
+
+		window.cron = cron
+		if window.Duration <= 0 {
+			return fmt.Errorf("schedule window %d: duration is required", i)
+		}
+
+		if window.OnEnd == "" {
+			window.OnEnd = WindowEndFinish
+		}
+
+		if window.OnEnd != WindowEndFinish && window.OnEnd != WindowEndPause {
+			return fmt.Errorf("schedule window %d: unknown onEnd: %s", i, window.OnEnd)
+		}
+
+		if window.OnEnd == WindowEndPause && !checkpoints {
+			return fmt.Errorf("schedule window %d: onEnd pause needs checkpoints to be enabled", i)
+		}
+	}
+
+	if *schedule.Enabled && len(schedule.Windows) == 0 {
+		return errors.New("schedule is enabled but has no windows")
+	}
+
+	return nil
+}
+
+type ScheduleInfo struct {
+	Enabled bool `json:"enabled"`
+	// Videos are only dispatched in a window unless they are run now
+	InWindow bool `json:"inWindow"`
+	// Cron of the current window
+	Window      string     `json:"window,omitempty"`
+	WindowEnd   *time.Time `json:"windowEnd,omitempty"`
+	NextWindow  *time.Time `json:"nextWindow,omitempty"`
+	WindowOnEnd string     `json:"windowOnEnd,omitempty"`
+}
+
+// State of the schedule at t, the current window is the
+// one that ends the latest when windows overlap
+func (s *ScheduleOptions) State(t time.Time) (ScheduleInfo, *ScheduleWindow) {
+	info := ScheduleInfo{Enabled: *s.Enabled}
+	if !info.Enabled {
+		info.InWindow = true
+		return info, nil
+	}
+
+	var current *ScheduleWindow
+	for i := range s.Windows {
+		window := &s.Windows[i]
+		if start, ok := window.lastStart(t); ok {
+			end := start.Add(window.duration())
+			if info.WindowEnd == nil || end.After(*info.WindowEnd) {
+				current = window
+				info.WindowEnd = &end
+			}
+		}
+
+		if start, ok := window.nextStart(t); ok {
+			if info.NextWindow == nil || start.Before(*info.NextWindow) {
+				info.NextWindow = &start
+			}
+		}
+	}
+
+	if current != nil {
+		info.InWindow = true
+		info.Window = current.Cron
+		info.WindowOnEnd = current.OnEnd
+	}
+
+	return info, current
+}
+
+// When the schedule state changes next, a window ending or starting
+func (info *ScheduleInfo) NextChange() (time.Time, bool) {
+	if !info.Enabled {
+		return time.Time{}, false
+	}
+
+	if info.InWindow && info.WindowEnd != nil {
+		// A window starting before the end doesn't change anything
+		return *info.WindowEnd, true
+	}
+
+	if info.NextWindow != nil {
+		return *info.NextWindow, true
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/Zelak312/interpolarr/rife-ncnn-vulkan-go"
+)
+
+// Settings of a video overriding the config, the values that are
+// not set use the config
+type VideoSettings struct {
+	TargetFPS                   float64        `json:"targetFPS,omitempty" yaml:"targetFPS"`
+	ModelPath                   string         `json:"modelPath,omitempty" yaml:"modelPath"`
+	Rife                        *RifeOptions   `json:"rife,omitempty" yaml:"rife"`
+	FFmpegOptions               *FFmpegOptions `json:"ffmpegOptions,omitempty" yaml:"ffmpegOptions"`
+	DeleteInputFileWhenFinished *bool          `json:"deleteInputFileWhenFinished,omitempty" yaml:"deleteInputFileWhenFinished"`
+	CopyFileToDestinationOnSkip *bool          `json:"copyFileToDestinationOnSkip,omitempty" yaml:"copyFileToDestinationOnSkip"`
+}
+
+// Tuning of rife, the rife defaults are used when not set
+type RifeOptions struct {
+	GPUID *int `json:"gpuId,omitempty" yaml:"gpuId"`
+	// Test time augmentation, better quality but slower
+	TTAMode     *bool `json:"ttaMode,omitempty" yaml:"ttaMode"`
+	TTATemporal *bool `json:"ttaTemporal,omitempty" yaml:"ttaTemporal"`
+	// Better for videos above 1080p
+	UHDMode *bool `json:"uhdMode,omitempty" yaml:"uhdMode"`
+	Threads int   `json:"threads,omitempty" yaml:"threads"`
+}
+
+func (s *VideoSettings) verify() error {
+	if s.TargetFPS < 0 {
+		return errors.New("targetFPS can't be negative")
+	}
+
+	if s.FFmpegOptions != nil && s.FFmpegOptions.CRF < 0 {
+		return errors.New("crf can't be negative")
+	}
+
+	if s.Rife != nil && s.Rife.Threads < 0 {
+		return errors.New("rife threads can't be negative")
+	}
+
+	return nil
+}
+
+// Override the settings of resolved with the ones that are set
+func (s *VideoSettings) mergeInto(resolved *VideoSettings) {
+	if s.TargetFPS != 0 {
+		resolved.TargetFPS = s.TargetFPS
+	}
+
+	if s.ModelPath != "" {
+		resolved.ModelPath = s.ModelPath
+	}
+
+	if s.Rife != nil {
+		if s.Rife.GPUID != nil {
+			resolved.Rife.GPUID = s.Rife.GPUID
+		}
+
+		if s.Rife.TTAMode != nil {
+			resolved.Rife.TTAMode = s.Rife.TTAMode
+		}
+
+		if s.Rife.TTATemporal != nil {
+			resolved.Rife.TTATemporal = s.Rife.TTATemporal
+		}
+
+		if s.Rife.UHDMode != nil {
+			resolved.Rife.UHDMode = s.Rife.UHDMode
+		}
+
+		if s.Rife.Threads != 0 {
+			resolved.Rife.Threads = s.Rife.Threads
+		}
+	}
+
+	if s.FFmpegOptions != nil {
+		if s.FFmpegOptions.HWAccelDecodeFlag != "" {
+			resolved.FFmpegOptions.HWAccelDecodeFlag = s.FFmpegOptions.HWAccelDecodeFlag
+		}
+
+		if s.FFmpegOptions.HWAccelEncodeFlag != "" {
+			resolved.FFmpegOptions.HWAccelEncodeFlag = s.FFmpegOptions.HWAccelEncodeFlag
+		}
+
+		if s.FFmpegOptions.VideoCodec != "" {
+			resolved.FFmpegOptions.VideoCodec = s.FFmpegOptions.VideoCodec
+		}
+
+		if s.FFmpegOptions.CRF != 0 {
+			resolved.FFmpegOptions.CRF = s.FFmpegOptions.CRF
+		}
+	}
+
+	if s.DeleteInputFileWhenFinished != nil {
+		resolved.DeleteInputFileWhenFinished = s.DeleteInputFileWhenFinished
+	}
+
+	if s.CopyFileToDestinationOnSkip != nil {
+		resolved.CopyFileToDestinationOnSkip = s.CopyFileToDestinationOnSkip
+	}
+}
+
+// The settings used to process the video: the config, overridden by the
+// profile of the video, overridden by the settings of the video. Every
+// value is set except the rife options
+func (v *Video) ResolveSettings(config *Config) VideoSettings {
+	ffmpegOptions := config.FFmpegOptions
+	resolved := VideoSettings{
+		TargetFPS:                   config.TargetFPS,
+		ModelPath:                   config.ModelPath,
+		Rife:                        &RifeOptions{},
+		FFmpegOptions:               &ffmpegOptions,
+		DeleteInputFileWhenFinished: config.DeleteInputFileWhenFinished,
+		CopyFileToDestinationOnSkip: config.CopyFileToDestinationOnSkip,
+	}
+
+	if profile, ok := config.Profiles[v.Profile]; ok {
+		profile.Settings.mergeInto(&resolved)
+	}
+
+	v.Settings.mergeInto(&resolved)
+	return resolved
+}
+
+// The settings of the video with the config
+func (w *Worker) settings(video *Video) VideoSettings {
+	return video.ResolveSettings(w.poolWorker.config)
+}
+
+// The rife config with the options that are set
+func (o *RifeOptions) config(width int, height int) *rife.Config {
+	config := rife.DefaultConfig(width, height)
+	if o == nil {
+		return config
+	}
+
+	if o.GPUID != nil {
+		config.GPUID = *o.GPUID
+	}
+
+	if o.TTAMode != nil {
+		config.TTAMode = *o.TTAMode
+	}
+
+	if o.TTATemporal != nil {
+		config.TTATemporal = *o.TTATemporal
+	}
+
+	if o.UHDMode != nil {
+		config.UHDMode = *o.UHDMode
+	}
+
+	if o.Threads != 0 {
+		config.NumThreads = o.Threads
+	}
+
+	return config
+}
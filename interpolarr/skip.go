@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Why the video is skipped, empty when it's interpolated
+func (o *SkipOptions) Reason(videoInfo *VideoInfo, targetFPS float64) string {
+	if *o.SkipInterpolated {
+		if tags := ReadInterpolarrTags(videoInfo); tags != nil {
+			return fmt.Sprintf("already interpolated by interpolarr %s (job %d)", tags.Version, tags.JobID)
+		}
+	}
+
+	if videoInfo.FrameRate >= targetFPS-*o.FPSTolerance {
+		return fmt.Sprintf("fps %.3f is already at the target fps %.3f", videoInfo.FrameRate, targetFPS)
+	}
+
+	if videoInfo.Duration < o.MinDuration {
+		return fmt.Sprintf("duration %.1fs is shorter than %.1fs", videoInfo.Duration, o.MinDuration)
+	}
+
+	if o.MaxDuration != 0 && videoInfo.Duration > o.MaxDuration {
+		return fmt.Sprintf("duration %.1fs is longer than %.1fs", videoInfo.Duration, o.MaxDuration)
+	}
+
+	if !inRange(videoInfo.Width, o.MinWidth, o.MaxWidth) || !inRange(videoInfo.Height, o.MinHeight, o.MaxHeight) {
+		return fmt.Sprintf("resolution %dx%d is outside of the limits", videoInfo.Width, videoInfo.Height)
+	}
+
+	if len(o.AllowCodecs) > 0 && !containsFold(o.AllowCodecs, videoInfo.Codec) {
+		return fmt.Sprintf("codec %s is not allowed", videoInfo.Codec)
+	}
+
+	if containsFold(o.DenyCodecs, videoInfo.Codec) {
+		return fmt.Sprintf("codec %s is denied", videoInfo.Codec)
+	}
+
+	return ""
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"embed"
+	"encoding/json"
+	"errors"
 	"io/fs"
+	"strings"
 	"time"
 
 	_ "github.com/glebarez/go-sqlite"
@@ -37,6 +40,10 @@ func NewSqlite(path string) Sqlite {
 	}
 }
 
+func (s *Sqlite) Close() error {
+	return s.pool.Close()
+}
+
 //go:embed migrations/*.sql
 var embedMigrations embed.FS
 
@@ -67,8 +74,83 @@ func (s *Sqlite) RunMigrations() {
 	}
 }
 
+// Videos without a batch have a NULL batch
+func toBatchColumn(batchID int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: batchID, Valid: batchID != 0}
+}
+
+// Optional times are stored as unix seconds in an INTEGER column
+func toTimeColumn(value *time.Time) sql.NullInt64 {
+	if value == nil {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: value.Unix(), Valid: true}
+}
+
+func fromTimeColumn(column sql.NullInt64) *time.Time {
+	if !column.Valid {
+		return nil
+	}
+
+	value := time.Unix(column.Int64, 0)
+	return &value
+}
+
+// Optional structs are stored as json in a TEXT column
+func toJSONColumn(value interface{}) (sql.NullString, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	if string(data) == "null" {
+		return sql.NullString{}, nil
+	}
+
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func fromJSONColumn(column sql.NullString, value interface{}) error {
+	if !column.Valid || column.String == "" {
+		return nil
+	}
+
+	return json.Unmarshal([]byte(column.String), value)
+}
+
+const videoColumns = `id, path, output_path, comparison, mode, priority, run_now, not_before, settings, profile, rule,
+				batch_id, labels`
+
+func scanVideo(row interface{ Scan(...any) error }) (Video, error) {
+	var v Video
+	var comparison sql.NullString
+	var notBefore sql.NullInt64
+	var settings sql.NullString
+	var batchID sql.NullInt64
+	var labels sql.NullString
+	if err := row.Scan(&v.ID, &v.Path, &v.OutputPath, &comparison, &v.Mode, &v.Priority, &v.RunNow,
+		&notBefore, &settings, &v.Profile, &v.Rule, &batchID, &labels); err != nil {
+		return v, err
+	}
+
+	v.BatchID = batchID.Int64
+	if err := fromJSONColumn(labels, &v.Labels); err != nil {
+		return v, err
+	}
+
+	v.NotBefore = fromTimeColumn(notBefore)
+	if err := fromJSONColumn(settings, &v.Settings); err != nil {
+		return v, err
+	}
+
+	err := fromJSONColumn(comparison, &v.Comparison)
+	return v, err
+}
+
 func (s *Sqlite) GetVideos() ([]Video, error) {
-	querySQL := `SELECT id, path, output_path FROM videos WHERE done = false AND failed = false`
+	querySQL := `SELECT ` + videoColumns + ` FROM videos
+				WHERE done = false AND failed = false AND cancelled = false ORDER BY priority DESC, position ASC`
 	rows, err := s.pool.Query(querySQL)
 	if err != nil {
 		return []Video{}, err
@@ -77,10 +159,11 @@ func (s *Sqlite) GetVideos() ([]Video, error) {
 	defer rows.Close()
 	videos := []Video{}
 	for rows.Next() {
-		var v Video
-		if err := rows.Scan(&v.ID, &v.Path, &v.OutputPath); err != nil {
+		v, err := scanVideo(rows)
+		if err != nil {
 			return videos, err
 		}
+
 		videos = append(videos, v)
 	}
 
@@ -92,15 +175,73 @@ func (s *Sqlite) GetVideos() ([]Video, error) {
 	return videos, nil
 }
 
+func (s *Sqlite) GetVideoByID(id int64) (Video, bool, error) {
+	querySQL := `SELECT ` + videoColumns + ` FROM videos WHERE id = ?`
+	video, err := scanVideo(s.pool.QueryRow(querySQL, id))
+	if err == sql.ErrNoRows {
+		return Video{}, false, nil
+	}
+
+	if err != nil {
+		return Video{}, false, err
+	}
+
+	return video, true, nil
+}
+
+// Every video, whatever their state
+func (s *Sqlite) GetAllVideos() ([]Video, error) {
+	querySQL := `SELECT id, path, output_path, mode FROM videos`
+	rows, err := s.pool.Query(querySQL)
+	if err != nil {
+		return []Video{}, err
+	}
+
+	defer rows.Close()
+	videos := []Video{}
+	for rows.Next() {
+		var v Video
+		if err := rows.Scan(&v.ID, &v.Path, &v.OutputPath, &v.Mode); err != nil {
+			return videos, err
+		}
+
+		videos = append(videos, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []Video{}, err
+	}
+
+	return videos, nil
+}
+
 func (s *Sqlite) InsertVideo(video *Video) (int64, error) {
-	insertSQL := `INSERT INTO videos (path, output_path, done) VALUES (?, ?, ?)`
+	comparison, err := toJSONColumn(video.Comparison)
+	if err != nil {
+		return 0, err
+	}
+
+	settings, err := toJSONColumn(video.Settings)
+	if err != nil {
+		return 0, err
+	}
+
+	labels, err := toJSONColumn(video.Labels)
+	if err != nil {
+		return 0, err
+	}
+
+	insertSQL := `INSERT INTO videos (path, output_path, done, comparison, mode, priority, run_now, settings,
+				profile, rule, batch_id, labels, position)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM videos))`
 	statement, err := s.pool.Prepare(insertSQL)
 	if err != nil {
 		return 0, err
 	}
 
 	defer statement.Close()
-	result, err := statement.Exec(video.Path, video.OutputPath, false)
+	result, err := statement.Exec(video.Path, video.OutputPath, false, comparison, video.Mode, video.Priority,
+		video.RunNow, settings, video.Profile, video.Rule, toBatchColumn(video.BatchID), labels)
 	if err != nil {
 		return 0, err
 	}
@@ -115,7 +256,12 @@ func (s *Sqlite) InsertVideo(video *Video) (int64, error) {
 }
 
 func (s *Sqlite) MarkVideoAsDone(video *Video) error {
-	updateSQL := `UPDATE videos SET done = true WHERE id = ?`
+	return s.MarkVideoAsSkipped(video, "")
+}
+
+// Done without being interpolated, for the reason given
+func (s *Sqlite) MarkVideoAsSkipped(video *Video, reason string) error {
+	updateSQL := `UPDATE videos SET done = true, skip_reason = ?, finished_at = ? WHERE id = ?`
 	statement, err := s.pool.Prepare(updateSQL)
 	if err != nil {
 		return err
@@ -123,7 +269,7 @@ func (s *Sqlite) MarkVideoAsDone(video *Video) error {
 	defer statement.Close()
 
 	// Execute the statement with the provided video ID
-	_, err = statement.Exec(video.ID)
+	_, err = statement.Exec(reason, time.Now().Unix(), video.ID)
 	if err != nil {
 		return err
 	}
@@ -131,6 +277,139 @@ func (s *Sqlite) MarkVideoAsDone(video *Video) error {
 	return nil
 }
 
+func (s *Sqlite) SetVideoFingerprint(video *Video, fingerprint string) error {
+	updateSQL := `UPDATE videos SET fingerprint = ? WHERE id = ?`
+	_, err := s.pool.Exec(updateSQL, fingerprint, video.ID)
+	return err
+}
+
+// The interpolated videos with the fingerprint, last finished first
+func (s *Sqlite) GetDoneVideosByFingerprint(fingerprint string, excludeID int64) ([]Video, error) {
+	querySQL := `SELECT ` + videoColumns + ` FROM videos
+				WHERE fingerprint = ? AND id != ? AND done = true AND mode = ?
+				ORDER BY finished_at DESC, id DESC`
+	rows, err := s.pool.Query(querySQL, fingerprint, excludeID, JobModeInterpolate)
+	if err != nil {
+		return []Video{}, err
+	}
+
+	defer rows.Close()
+	videos := []Video{}
+	for rows.Next() {
+		v, err := scanVideo(rows)
+		if err != nil {
+			return videos, err
+		}
+
+		videos = append(videos, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []Video{}, err
+	}
+
+	return videos, nil
+}
+
+func (s *Sqlite) UpdateVideoLabels(video *Video) error {
+	labels, err := toJSONColumn(video.Labels)
+	if err != nil {
+		return err
+	}
+
+	updateSQL := `UPDATE videos SET labels = ? WHERE id = ?`
+	_, err = s.pool.Exec(updateSQL, labels, video.ID)
+	return err
+}
+
+func (s *Sqlite) UpdateVideoPriority(video *Video) error {
+	updateSQL := `UPDATE videos SET priority = ? WHERE id = ?`
+	statement, err := s.pool.Prepare(updateSQL)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(video.Priority, video.ID)
+	return err
+}
+
+func (s *Sqlite) UpdateVideoRunNow(video *Video) error {
+	updateSQL := `UPDATE videos SET run_now = ? WHERE id = ?`
+	statement, err := s.pool.Prepare(updateSQL)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(video.RunNow, video.ID)
+	return err
+}
+
+// Put the video after every other video of its priority
+func (s *Sqlite) MoveVideoToBack(video *Video) error {
+	updateSQL := `UPDATE videos SET position = (SELECT COALESCE(MAX(position), 0) + 1 FROM videos) WHERE id = ?`
+	statement, err := s.pool.Prepare(updateSQL)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(video.ID)
+	return err
+}
+
+// Save the order of the queue, the position of
+// each video being its index in the queue
+func (s *Sqlite) UpdateQueuePositions(videos []Video) error {
+	tx, err := s.pool.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	updateSQL := `UPDATE videos SET position = ?, priority = ? WHERE id = ?`
+	statement, err := tx.Prepare(updateSQL)
+	if err != nil {
+		return err
+	}
+
+	defer statement.Close()
+	// The chunks of a video share its id, the
+	// video takes the position of its first chunk
+	saved := map[int64]bool{}
+	for i, video := range videos {
+		if saved[video.ID] {
+			continue
+		}
+
+		saved[video.ID] = true
+		_, err = statement.Exec(i+1, video.Priority, video.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Sqlite) CancelVideo(video *Video) error {
+	updateSQL := `UPDATE videos SET cancelled = true, finished_at = ? WHERE id = ?`
+	statement, err := s.pool.Prepare(updateSQL)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(time.Now().Unix(), video.ID)
+	return err
+}
+
 func (s *Sqlite) GetVideoRetries(video *Video) (int, error) {
 	getRetrySQL := `SELECT retries FROM videos WHERE id = ?`
 	statement, err := s.pool.Prepare(getRetrySQL)
@@ -148,15 +427,16 @@ func (s *Sqlite) GetVideoRetries(video *Video) (int, error) {
 	return retries, nil
 }
 
+// Save the retries and when the video can be retried
 func (s *Sqlite) UpdateVideoRetries(video *Video, retries int) error {
-	updateSQL := `UPDATE videos SET retries = ? WHERE id = ?`
+	updateSQL := `UPDATE videos SET retries = ?, not_before = ? WHERE id = ?`
 	statement, err := s.pool.Prepare(updateSQL)
 	if err != nil {
 		return err
 	}
 	defer statement.Close()
 
-	_, err = statement.Exec(retries, video.ID)
+	_, err = statement.Exec(retries, toTimeColumn(video.NotBefore), video.ID)
 	if err != nil {
 		return err
 	}
@@ -188,14 +468,14 @@ func (s *Sqlite) FailVideo(video *Video, output string, progErr string) error {
 		return err
 	}
 
-	markFailedSQL := `UPDATE videos SET failed = ? WHERE id = ?`
+	markFailedSQL := `UPDATE videos SET failed = ?, finished_at = ? WHERE id = ?`
 	statement, err = s.pool.Prepare(markFailedSQL)
 	if err != nil {
 		return err
 	}
 	defer statement.Close()
 
-	_, err = statement.Exec(true, video.ID)
+	_, err = statement.Exec(true, time.Now().Unix(), video.ID)
 	if err != nil {
 		return err
 	}
@@ -203,6 +483,73 @@ func (s *Sqlite) FailVideo(video *Video, output string, progErr string) error {
 	return tx.Commit()
 }
 
+func (s *Sqlite) InsertAttempt(attempt *VideoAttempt) error {
+	insertSQL := `INSERT INTO video_attempts (video_id, attempt, chunk, started_at, ended_at, result, error, permanent, retry_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	statement, err := s.pool.Prepare(insertSQL)
+	if err != nil {
+		return err
+	}
+
+	defer statement.Close()
+	var chunk sql.NullInt64
+	if attempt.Chunk != nil {
+		chunk = sql.NullInt64{Int64: int64(*attempt.Chunk), Valid: true}
+	}
+
+	result, err := statement.Exec(attempt.VideoID, attempt.Attempt, chunk, toTimeColumn(&attempt.StartedAt),
+		toTimeColumn(&attempt.EndedAt), attempt.Result, attempt.Error, attempt.Permanent, toTimeColumn(attempt.RetryAt))
+	if err != nil {
+		return err
+	}
+
+	attempt.ID, err = result.LastInsertId()
+	return err
+}
+
+// Attempts of the video, oldest first
+func (s *Sqlite) GetAttempts(videoID int64) ([]VideoAttempt, error) {
+	querySQL := `SELECT id, video_id, attempt, chunk, started_at, ended_at, result, error, permanent, retry_at
+				FROM video_attempts WHERE video_id = ? ORDER BY id`
+	rows, err := s.pool.Query(querySQL, videoID)
+	if err != nil {
+		return []VideoAttempt{}, err
+	}
+
+	defer rows.Close()
+	attempts := []VideoAttempt{}
+	for rows.Next() {
+		var a VideoAttempt
+		var chunk, startedAt, endedAt, retryAt sql.NullInt64
+		if err := rows.Scan(&a.ID, &a.VideoID, &a.Attempt, &chunk, &startedAt, &endedAt,
+			&a.Result, &a.Error, &a.Permanent, &retryAt); err != nil {
+			return attempts, err
+		}
+
+		if chunk.Valid {
+			index := int(chunk.Int64)
+			a.Chunk = &index
+		}
+
+		if t := fromTimeColumn(startedAt); t != nil {
+			a.StartedAt = *t
+		}
+
+		if t := fromTimeColumn(endedAt); t != nil {
+			a.EndedAt = *t
+		}
+
+		a.RetryAt = fromTimeColumn(retryAt)
+		attempts = append(attempts, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []VideoAttempt{}, err
+	}
+
+	return attempts, nil
+}
+
 func (s *Sqlite) DeleteVideoByID(tx *sql.Tx, id int64) error {
 	deleteSQL := `DELETE FROM videos WHERE id = ?`
 	var statement *sql.Stmt
@@ -222,10 +569,11 @@ func (s *Sqlite) DeleteVideoByID(tx *sql.Tx, id int64) error {
 	return err
 }
 
-func (s *Sqlite) GetFailedVideos() ([]FailedVideo, error) {
-	querySQL := `SELECT f.id, f.ffmpeg_output, f.error, v.id, v.path, v.output_path FROM failed_videos f
-				INNER JOIN videos v ON v.id = f.video_id`
-	rows, err := s.pool.Query(querySQL)
+// The failed records, the archived ones are the failures of videos that were retried
+func (s *Sqlite) GetFailedVideos(archived bool) ([]FailedVideo, error) {
+	querySQL := `SELECT f.id, f.ffmpeg_output, f.error, f.archived, v.id, v.path, v.output_path, v.batch_id, v.labels
+				FROM failed_videos f INNER JOIN videos v ON v.id = f.video_id WHERE f.archived = ?`
+	rows, err := s.pool.Query(querySQL, archived)
 	if err != nil {
 		return []FailedVideo{}, err
 	}
@@ -234,7 +582,15 @@ func (s *Sqlite) GetFailedVideos() ([]FailedVideo, error) {
 	videos := []FailedVideo{}
 	for rows.Next() {
 		var v FailedVideo
-		if err := rows.Scan(&v.ID, &v.FFmpegOutput, &v.Error, &v.Video.ID, &v.Video.Path, &v.Video.OutputPath); err != nil {
+		var batchID sql.NullInt64
+		var labels sql.NullString
+		if err := rows.Scan(&v.ID, &v.FFmpegOutput, &v.Error, &v.Archived, &v.Video.ID, &v.Video.Path, &v.Video.OutputPath,
+			&batchID, &labels); err != nil {
+			return videos, err
+		}
+
+		v.Video.BatchID = batchID.Int64
+		if err := fromJSONColumn(labels, &v.Video.Labels); err != nil {
 			return videos, err
 		}
 		videos = append(videos, v)
@@ -247,3 +603,421 @@ func (s *Sqlite) GetFailedVideos() ([]FailedVideo, error) {
 
 	return videos, nil
 }
+
+// Put the failed video back in the queue state, at the back of its priority with
+// its retries reset. Its failed records are archived
+func (s *Sqlite) RetryFailedVideo(videoID int64) error {
+	tx, err := s.pool.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	updateSQL := `UPDATE videos SET failed = false, retries = 0, not_before = NULL, finished_at = NULL,
+				position = (SELECT COALESCE(MAX(position), 0) + 1 FROM videos) WHERE id = ?`
+	_, err = tx.Exec(updateSQL, videoID)
+	if err != nil {
+		return err
+	}
+
+	archiveSQL := `UPDATE failed_videos SET archived = true WHERE video_id = ?`
+	_, err = tx.Exec(archiveSQL, videoID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Sqlite) DeleteFailedVideo(id int64) (bool, error) {
+	deleteSQL := `DELETE FROM failed_videos WHERE id = ?`
+	result, err := s.pool.Exec(deleteSQL, id)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+func (s *Sqlite) InsertCheckpoint(video *Video, checkpoint VideoCheckpoint) error {
+	insertSQL := `INSERT INTO video_checkpoints (video_id, part_path, last_frame) VALUES (?, ?, ?)`
+	statement, err := s.pool.Prepare(insertSQL)
+	if err != nil {
+		return err
+	}
+
+	defer statement.Close()
+	_, err = statement.Exec(video.ID, checkpoint.PartPath, checkpoint.LastFrame)
+	return err
+}
+
+func (s *Sqlite) GetCheckpoints(video *Video) ([]VideoCheckpoint, error) {
+	querySQL := `SELECT part_path, last_frame FROM video_checkpoints WHERE video_id = ? ORDER BY last_frame`
+	rows, err := s.pool.Query(querySQL, video.ID)
+	if err != nil {
+		return []VideoCheckpoint{}, err
+	}
+
+	defer rows.Close()
+	checkpoints := []VideoCheckpoint{}
+	for rows.Next() {
+		var c VideoCheckpoint
+		if err := rows.Scan(&c.PartPath, &c.LastFrame); err != nil {
+			return checkpoints, err
+		}
+		checkpoints = append(checkpoints, c)
+	}
+
+	// Check for errors from iterating over rows
+	if err := rows.Err(); err != nil {
+		return []VideoCheckpoint{}, err
+	}
+
+	return checkpoints, nil
+}
+
+func (s *Sqlite) DeleteCheckpoints(video *Video) error {
+	deleteSQL := `DELETE FROM video_checkpoints WHERE video_id = ?`
+	statement, err := s.pool.Prepare(deleteSQL)
+	if err != nil {
+		return err
+	}
+
+	defer statement.Close()
+	_, err = statement.Exec(video.ID)
+	return err
+}
+
+func (s *Sqlite) InsertValidationResult(result *ValidationResult) error {
+	insertSQL := `INSERT INTO validation_results (video_id, model_path, samples, psnr, ssim, min_psnr, min_ssim)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`
+	statement, err := s.pool.Prepare(insertSQL)
+	if err != nil {
+		return err
+	}
+
+	defer statement.Close()
+	res, err := statement.Exec(result.Video.ID, result.ModelPath, result.Samples,
+		result.PSNR, result.SSIM, result.MinPSNR, result.MinSSIM)
+	if err != nil {
+		return err
+	}
+
+	result.ID, err = res.LastInsertId()
+	return err
+}
+
+const validationResultsSQL = `SELECT r.id, r.model_path, r.samples, r.psnr, r.ssim, r.min_psnr, r.min_ssim,
+				v.id, v.path, v.output_path, v.mode FROM validation_results r
+				INNER JOIN videos v ON v.id = r.video_id`
+
+func scanValidationResult(row interface{ Scan(...any) error }) (ValidationResult, error) {
+	var r ValidationResult
+	err := row.Scan(&r.ID, &r.ModelPath, &r.Samples, &r.PSNR, &r.SSIM, &r.MinPSNR, &r.MinSSIM,
+		&r.Video.ID, &r.Video.Path, &r.Video.OutputPath, &r.Video.Mode)
+	return r, err
+}
+
+func (s *Sqlite) GetValidationResults() ([]ValidationResult, error) {
+	rows, err := s.pool.Query(validationResultsSQL)
+	if err != nil {
+		return []ValidationResult{}, err
+	}
+
+	defer rows.Close()
+	results := []ValidationResult{}
+	for rows.Next() {
+		r, err := scanValidationResult(rows)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, r)
+	}
+
+	// Check for errors from iterating over rows
+	if err := rows.Err(); err != nil {
+		return []ValidationResult{}, err
+	}
+
+	return results, nil
+}
+
+func (s *Sqlite) GetValidationResultByVideoID(videoID int64) (ValidationResult, bool, error) {
+	row := s.pool.QueryRow(validationResultsSQL+` WHERE v.id = ? ORDER BY r.id DESC LIMIT 1`, videoID)
+	r, err := scanValidationResult(row)
+	if err == sql.ErrNoRows {
+		return ValidationResult{}, false, nil
+	}
+
+	if err != nil {
+		return ValidationResult{}, false, err
+	}
+
+	return r, true, nil
+}
+
+func (s *Sqlite) GetState(key string) (string, bool, error) {
+	querySQL := `SELECT value FROM app_state WHERE key = ?`
+	value := ""
+	err := s.pool.QueryRow(querySQL, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return value, true, nil
+}
+
+func (s *Sqlite) SetState(key string, value string) error {
+	upsertSQL := `INSERT INTO app_state (key, value) VALUES (?, ?)
+				ON CONFLICT(key) DO UPDATE SET value = excluded.value`
+	statement, err := s.pool.Prepare(upsertSQL)
+	if err != nil {
+		return err
+	}
+
+	defer statement.Close()
+	_, err = statement.Exec(key, value)
+	return err
+}
+
+// Video created by the request with the idempotency key, keys
+// older than maxAge are forgotten
+func (s *Sqlite) GetIdempotencyKey(key string, maxAge time.Duration) (int64, bool, error) {
+	deleteSQL := `DELETE FROM idempotency_keys WHERE created_at < ?`
+	_, err := s.pool.Exec(deleteSQL, time.Now().Add(-maxAge).Unix())
+	if err != nil {
+		return 0, false, err
+	}
+
+	querySQL := `SELECT video_id FROM idempotency_keys WHERE key = ?`
+	var videoID int64
+	err = s.pool.QueryRow(querySQL, key).Scan(&videoID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return videoID, true, nil
+}
+
+func (s *Sqlite) InsertIdempotencyKey(key string, videoID int64) error {
+	insertSQL := `INSERT INTO idempotency_keys (key, video_id, created_at) VALUES (?, ?, ?)
+				ON CONFLICT(key) DO NOTHING`
+	_, err := s.pool.Exec(insertSQL, key, videoID, time.Now().Unix())
+	return err
+}
+
+func (s *Sqlite) GetWatchedFiles() ([]WatchedFile, error) {
+	querySQL := `SELECT path, size, mod_time, video_id FROM watched_files`
+	rows, err := s.pool.Query(querySQL)
+	if err != nil {
+		return []WatchedFile{}, err
+	}
+
+	defer rows.Close()
+	files := []WatchedFile{}
+	for rows.Next() {
+		var file WatchedFile
+		if err := rows.Scan(&file.Path, &file.Size, &file.ModTime, &file.VideoID); err != nil {
+			return files, err
+		}
+
+		files = append(files, file)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []WatchedFile{}, err
+	}
+
+	return files, nil
+}
+
+func (s *Sqlite) UpsertWatchedFile(file *WatchedFile) error {
+	insertSQL := `INSERT INTO watched_files (path, size, mod_time, video_id, created_at) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT(path) DO UPDATE SET size = excluded.size, mod_time = excluded.mod_time,
+				video_id = excluded.video_id, created_at = excluded.created_at`
+	_, err := s.pool.Exec(insertSQL, file.Path, file.Size, file.ModTime, file.VideoID, time.Now().Unix())
+	return err
+}
+
+// Scan the extra columns after the ones of the row
+type extraColumns struct {
+	row   interface{ Scan(...any) error }
+	extra []any
+}
+
+func (e extraColumns) Scan(dest ...any) error {
+	return e.row.Scan(append(dest, e.extra...)...)
+}
+
+// The finished videos, last finished first. Every status when status is empty,
+// only the videos with one of the labels when there are labels
+func (s *Sqlite) GetHistory(status string, labels []string, limit int) ([]HistoryEntry, error) {
+	conditions := map[string]string{
+		"":               `done = true OR failed = true OR cancelled = true`,
+		HistoryDone:      `done = true AND skip_reason = ''`,
+		HistorySkipped:   `done = true AND skip_reason != ''`,
+		HistoryFailed:    `failed = true`,
+		HistoryCancelled: `cancelled = true`,
+	}
+
+	condition, ok := conditions[status]
+	if !ok {
+		return []HistoryEntry{}, errors.New("unknown status: " + status)
+	}
+
+	args := []any{}
+	condition = `(` + condition + `)`
+	if len(labels) > 0 {
+		condition += ` AND EXISTS (SELECT 1 FROM json_each(videos.labels) WHERE json_each.value COLLATE NOCASE IN (?` +
+			strings.Repeat(`, ?`, len(labels)-1) + `))`
+		for _, label := range labels {
+			args = append(args, label)
+		}
+	}
+
+	querySQL := `SELECT ` + videoColumns + `, done, failed, skip_reason, finished_at FROM videos
+				WHERE ` + condition + ` ORDER BY finished_at DESC, id DESC LIMIT ?`
+	rows, err := s.pool.Query(querySQL, append(args, limit)...)
+	if err != nil {
+		return []HistoryEntry{}, err
+	}
+
+	defer rows.Close()
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		var entry HistoryEntry
+		var done, failed bool
+		var finishedAt sql.NullInt64
+		entry.Video, err = scanVideo(extraColumns{rows, []any{&done, &failed, &entry.SkipReason, &finishedAt}})
+		if err != nil {
+			return entries, err
+		}
+
+		entry.FinishedAt = fromTimeColumn(finishedAt)
+		switch {
+		case done && entry.SkipReason != "":
+			entry.Status = HistorySkipped
+		case done:
+			entry.Status = HistoryDone
+		case failed:
+			entry.Status = HistoryFailed
+		default:
+			entry.Status = HistoryCancelled
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []HistoryEntry{}, err
+	}
+
+	return entries, nil
+}
+
+func (s *Sqlite) InsertBatch(batch *Batch) error {
+	insertSQL := `INSERT INTO batches (name, paused, created_at) VALUES (?, ?, ?)`
+	result, err := s.pool.Exec(insertSQL, batch.Name, batch.Paused, batch.CreatedAt.Unix())
+	if err != nil {
+		return err
+	}
+
+	batch.ID, err = result.LastInsertId()
+	return err
+}
+
+func scanBatch(row interface{ Scan(...any) error }) (Batch, error) {
+	var b Batch
+	var createdAt, startedAt sql.NullInt64
+	if err := row.Scan(&b.ID, &b.Name, &b.Paused, &createdAt, &startedAt); err != nil {
+		return b, err
+	}
+
+	if t := fromTimeColumn(createdAt); t != nil {
+		b.CreatedAt = *t
+	}
+
+	b.StartedAt = fromTimeColumn(startedAt)
+	return b, nil
+}
+
+// Every batch, last created first
+func (s *Sqlite) GetBatches() ([]Batch, error) {
+	querySQL := `SELECT id, name, paused, created_at, started_at FROM batches ORDER BY id DESC`
+	rows, err := s.pool.Query(querySQL)
+	if err != nil {
+		return []Batch{}, err
+	}
+
+	defer rows.Close()
+	batches := []Batch{}
+	for rows.Next() {
+		b, err := scanBatch(rows)
+		if err != nil {
+			return batches, err
+		}
+
+		batches = append(batches, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []Batch{}, err
+	}
+
+	return batches, nil
+}
+
+func (s *Sqlite) GetBatchByID(id int64) (Batch, bool, error) {
+	querySQL := `SELECT id, name, paused, created_at, started_at FROM batches WHERE id = ?`
+	batch, err := scanBatch(s.pool.QueryRow(querySQL, id))
+	if err == sql.ErrNoRows {
+		return Batch{}, false, nil
+	}
+
+	if err != nil {
+		return Batch{}, false, err
+	}
+
+	return batch, true, nil
+}
+
+func (s *Sqlite) SetBatchPaused(id int64, paused bool) error {
+	updateSQL := `UPDATE batches SET paused = ? WHERE id = ?`
+	_, err := s.pool.Exec(updateSQL, paused, id)
+	return err
+}
+
+// Set when the first video of the batch starts, kept afterwards
+func (s *Sqlite) SetBatchStarted(id int64) error {
+	updateSQL := `UPDATE batches SET started_at = ? WHERE id = ? AND started_at IS NULL`
+	_, err := s.pool.Exec(updateSQL, time.Now().Unix(), id)
+	return err
+}
+
+// How many videos of the batch are in each finished state
+func (s *Sqlite) GetBatchCounts(id int64) (BatchCounts, error) {
+	querySQL := `SELECT COUNT(*),
+				COALESCE(SUM(done = true AND skip_reason = ''), 0),
+				COALESCE(SUM(done = true AND skip_reason != ''), 0),
+				COALESCE(SUM(failed = true), 0),
+				COALESCE(SUM(cancelled = true), 0)
+				FROM videos WHERE batch_id = ?`
+	var counts BatchCounts
+	err := s.pool.QueryRow(querySQL, id).Scan(&counts.Total, &counts.Done, &counts.Skipped,
+		&counts.Failed, &counts.Cancelled)
+	return counts, err
+}
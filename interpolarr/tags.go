@@ -0,0 +1,113 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metadata keys written in the outputs of interpolarr
+const (
+	tagVersion   = "interpolarr_version"
+	tagModel     = "interpolarr_model"
+	tagSourceFPS = "interpolarr_source_fps"
+	tagTargetFPS = "interpolarr_target_fps"
+	tagJobID     = "interpolarr_job_id"
+)
+
+// What the output was interpolated with
+type InterpolarrTags struct {
+	Version   string  `json:"version"`
+	Model     string  `json:"model"`
+	SourceFPS float64 `json:"sourceFPS"`
+	TargetFPS float64 `json:"targetFPS"`
+	JobID     int64   `json:"jobId"`
+}
+
+func NewInterpolarrTags(video *Video, settings *VideoSettings, videoInfo *VideoInfo) *InterpolarrTags {
+	return &InterpolarrTags{
+		Version:   Version,
+		Model:     filepath.Base(settings.ModelPath),
+		SourceFPS: videoInfo.FrameRate,
+		TargetFPS: settings.TargetFPS,
+		JobID:     video.ID,
+	}
+}
+
+// The tags of a video written by interpolarr, nil when there are none
+func ReadInterpolarrTags(videoInfo *VideoInfo) *InterpolarrTags {
+	version, ok := videoInfo.Tag(tagVersion)
+	if !ok {
+		return nil
+	}
+
+	tags := InterpolarrTags{Version: version}
+	tags.Model, _ = videoInfo.Tag(tagModel)
+	if value, ok := videoInfo.Tag(tagSourceFPS); ok {
+		tags.SourceFPS, _ = strconv.ParseFloat(value, 64)
+	}
+
+	if value, ok := videoInfo.Tag(tagTargetFPS); ok {
+		tags.TargetFPS, _ = strconv.ParseFloat(value, 64)
+	}
+
+	if value, ok := videoInfo.Tag(tagJobID); ok {
+		tags.JobID, _ = strconv.ParseInt(value, 10, 64)
+	}
+
+	return &tags
+}
+
+// The ffmpeg arguments writing the tags in the output
+func (t *InterpolarrTags) ffmpegArgs(outputPath string) []string {
+	if t == nil {
+		return []string{}
+	}
+
+	args := []string{
+		"-metadata", tagVersion + "=" + t.Version,
+		"-metadata", tagModel + "=" + t.Model,
+		"-metadata", tagSourceFPS + "=" + strconv.FormatFloat(t.SourceFPS, 'f', -1, 64),
+		"-metadata", tagTargetFPS + "=" + strconv.FormatFloat(t.TargetFPS, 'f', -1, 64),
+		"-metadata", tagJobID + "=" + strconv.FormatInt(t.JobID, 10),
+	}
+
+	// The mp4 muxer drops the keys it doesn't know without this flag
+	switch strings.ToLower(filepath.Ext(outputPath)) {
+	case ".mp4", ".m4v", ".mov":
+		args = append(args, "-movflags", "use_metadata_tags")
+	}
+
+	return args
+}
+
+type ProbeResult struct {
+	Info VideoInfo `json:"info"`
+	// Set when the video is an output of interpolarr
+	Interpolarr *InterpolarrTags `json:"interpolarr"`
+}
+
+type ProbeQuery struct {
+	Path string `form:"path" binding:"required"`
+}
+
+func probeVideo(c *gin.Context) {
+	var query ProbeQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	videoInfo, output, err := ProbeVideo(c.Request.Context(), query.Path)
+	if err != nil {
+		c.String(400, err.Error()+"\n"+output)
+		return
+	}
+
+	c.JSON(200, ProbeResult{
+		Info:        *videoInfo,
+		Interpolarr: ReadInterpolarrTags(videoInfo),
+	})
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	JobModeInterpolate = "interpolate"
+	// Measure the interpolation quality instead of producing an output
+	JobModeValidate = "validate"
+
+	// PSNR given when both frames are identical
+	maxPSNR = 100.0
+	// SSIM is computed over blocks of this size
+	ssimBlockSize = 8
+)
+
+// Quality of the interpolation of a video, every other source frame is
+// dropped and interpolated back, then compared with the real frame
+type ValidationResult struct {
+	ID        int64   `json:"id"`
+	Video     Video   `json:"video"`
+	ModelPath string  `json:"modelPath"`
+	Samples   int64   `json:"samples"`
+	PSNR      float64 `json:"psnr"`
+	SSIM      float64 `json:"ssim"`
+	MinPSNR   float64 `json:"minPSNR"`
+	MinSSIM   float64 `json:"minSSIM"`
+}
+
+// PSNR of two rgb24 frames of the same size
+func framePSNR(a []byte, b []byte) float64 {
+	sum := 0.0
+	for i := range a {
+		diff := float64(a[i]) - float64(b[i])
+		sum += diff * diff
+	}
+
+	mse := sum / float64(len(a))
+	if mse == 0 {
+		return maxPSNR
+	}
+
+	return min(10*math.Log10(255*255/mse), maxPSNR)
+}
+
+func luma(frame []byte, width int, height int) []float64 {
+	y := make([]float64, width*height)
+	for i := range y {
+		y[i] = 0.299*float64(frame[i*3]) + 0.587*float64(frame[i*3+1]) + 0.114*float64(frame[i*3+2])
+	}
+
+	return y
+}
+
+// Mean SSIM of the luma of two rgb24 frames, computed over 8x8 blocks
+func frameSSIM(a []byte, b []byte, width int, height int) float64 {
+	const c1 = (0.01 * 255) * (0.01 * 255)
+	const c2 = (0.03 * 255) * (0.03 * 255)
+
+	ya := luma(a, width, height)
+	yb := luma(b, width, height)
+
+	total := 0.0
+	blocks := 0
+	n := float64(ssimBlockSize * ssimBlockSize)
+	for by := 0; by+ssimBlockSize <= height; by += ssimBlockSize {
+		for bx := 0; bx+ssimBlockSize <= width; bx += ssimBlockSize {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for y := by; y < by+ssimBlockSize; y++ {
+				for x := bx; x < bx+ssimBlockSize; x++ {
+					pa := ya[y*width+x]
+					pb := yb[y*width+x]
+					sumA += pa
+					sumB += pb
+					sumAA += pa * pa
+					sumBB += pb * pb
+					sumAB += pa * pb
+				}
+			}
+
+			meanA := sumA / n
+			meanB := sumB / n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			covar := sumAB/n - meanA*meanB
+
+			total += ((2*meanA*meanB + c1) * (2*covar + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			blocks++
+		}
+	}
+
+	if blocks == 0 {
+		return 1
+	}
+
+	return total / float64(blocks)
+}
+
+func (w *Worker) doValidationWork(video *Video) error {
+	result, output, err := w.validateVideo(video)
+	if w.ctx().Err() != nil {
+		return nil
+	}
+
+	if err != nil {
+		w.handleProcessVideoError(video, output, &ProcessVideoOutput{err: err})
+		return nil
+	}
+
+	w.logger.WithFields(StructFields(result)).Info("Validation done")
+	err = sqlite.InsertValidationResult(result)
+	if err != nil {
+		w.logger.Error("Failed to save validation result: ", err)
+		return err
+	}
+
+	err = sqlite.MarkVideoAsDone(video)
+	if err != nil {
+		w.logger.Error("Failed to mark video as done: ", err)
+		return err
+	}
+
+	w.recordAttempt(video, AttemptDone, nil, false)
+	return nil
+}
+
+func (w *Worker) validateVideo(video *Video) (*ValidationResult, string, error) {
+	w.logger.WithFields(StructFields(video)).Info("Validating video")
+	w.updateStep("Getting video information")
+	videoInfo, output, err := GetVideoInfo(w.ctx(), video.Path)
+	if err != nil {
+		return nil, output, err
+	}
+
+	settings := w.settings(video)
+	modelPath := settings.ModelPath
+	r, err := newRife(videoInfo, modelPath, settings.Rife)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer r.Close()
+	vp, err := NewVideoProcessor(videoInfo, *settings.FFmpegOptions)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := vp.StartReading(w.ctx()); err != nil {
+		return nil, "", err
+	}
+
+	defer vp.Close()
+	progressChan := make(chan float64)
+	defer close(progressChan)
+	go w.updateProgress(progressChan)
+	w.updateStep("Validating interpolation")
+
+	result := ValidationResult{
+		Video:     *video,
+		ModelPath: modelPath,
+		MinPSNR:   maxPSNR,
+		MinSSIM:   1,
+	}
+
+	maxSamples := int64(w.poolWorker.config.Validation.MaxSamples)
+	triplets := (videoInfo.FrameCount - 1) / 2
+	samples := min(triplets, maxSamples)
+	// The scored triplets are spread over the whole video
+	step := int64(1)
+	if samples > 0 {
+		step = max(triplets/samples, 1)
+	}
+
+	previous, err := vp.ReadFrame()
+	if err != nil {
+		return nil, "", err
+	}
+
+	for triplet := int64(0); result.Samples < samples; triplet++ {
+		// The middle frame is dropped and interpolated back
+		expected, err := vp.ReadFrame()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return nil, "", err
+		}
+
+		next, err := vp.ReadFrame()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return nil, "", err
+		}
+
+		if triplet%step != 0 {
+			previous = next
+			continue
+		}
+
+		interpolated, err := r.InterpolateBGR(previous.Data, next.Data, 0.5)
+		if err != nil {
+			return nil, "", err
+		}
+
+		psnr := framePSNR(interpolated, expected.Data)
+		ssim := frameSSIM(interpolated, expected.Data, videoInfo.Width, videoInfo.Height)
+		result.PSNR += psnr
+		result.SSIM += ssim
+		result.MinPSNR = min(result.MinPSNR, psnr)
+		result.MinSSIM = min(result.MinSSIM, ssim)
+		result.Samples++
+
+		previous = next
+		progressChan <- float64(result.Samples) / float64(samples) * 100
+	}
+
+	if result.Samples == 0 {
+		return nil, "", &PermanentError{errors.New("video is too short to be validated")}
+	}
+
+	result.PSNR /= float64(result.Samples)
+	result.SSIM /= float64(result.Samples)
+	return &result, "", nil
+}
+
+func listValidationResults(c *gin.Context) {
+	log.Debug("Getting validation results")
+	results, err := sqlite.GetValidationResults()
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	c.JSON(200, results)
+}
+
+func getValidationResult(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	result, ok, err := sqlite.GetValidationResultByVideoID(id)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+
+	if !ok {
+		c.String(404, "validation result not found")
+		return
+	}
+
+	c.JSON(200, result)
+}
@@ -0,0 +1,4 @@
+package main
+
+// managed by release.sh
+const Version = "1.1.0-beta"
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// How often the size of the new files is checked
+const watchCheckInterval = time.Second
+
+// A file of a watched folder that was added to the queue, the
+// file is added again only if it changes
+type WatchedFile struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	VideoID int64  `json:"videoId"`
+}
+
+func (f *WatchedFile) Same(info fs.FileInfo) bool {
+	return f.Size == info.Size() && f.ModTime == info.ModTime().Unix()
+}
+
+// A new file waiting for its size to stop changing
+type pendingFile struct {
+	folder    *WatchFolder
+	relPath   string
+	size      int64
+	modTime   time.Time
+	changedAt time.Time
+}
+
+type Watcher struct {
+	ctx       context.Context
+	config    *Config
+	logger    *logrus.Entry
+	fsWatcher *fsnotify.Watcher
+	// Folders that receive the fsnotify events, the others are polled
+	notified map[*WatchFolder]bool
+	pending  map[string]*pendingFile
+	ledger   map[string]WatchedFile
+	// Files that were not added, until they change
+	ignored map[string]WatchedFile
+	stopped chan struct{}
+}
+
+func verifyWatch(watch *WatchOptions, profiles map[string]Profile) error {
+	for i := range watch.Folders {
+		folder := &watch.Folders[i]
+		if folder.Path == "" {
+			return fmt.Errorf("watch folder %d: path is required", i)
+		}
+
+		folder.Path = absPath(folder.Path)
+		if folder.StableTime == 0 {
+			folder.StableTime = 30
+		}
+
+		if folder.Mode != "" && folder.Mode != JobModeInterpolate && folder.Mode != JobModeValidate {
+			return fmt.Errorf("watch folder %d: unknown mode: %s", i, folder.Mode)
+		}
+
+		if err := folder.Settings.verify(); err != nil {
+			return fmt.Errorf("watch folder %d: %v", i, err)
+		}
+
+		if _, ok := profiles[folder.Profile]; folder.Profile != "" && !ok {
+			return fmt.Errorf("watch folder %d: unknown profile: %s", i, folder.Profile)
+		}
+	}
+
+	return nil
+}
+
+func NewWatcher(ctx context.Context, config *Config) (*Watcher, error) {
+	logger, err := CreateLogger("watcher")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := sqlite.GetWatchedFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	ledger := map[string]WatchedFile{}
+	for _, file := range files {
+		ledger[file.Path] = file
+	}
+
+	return &Watcher{
+		ctx:      ctx,
+		config:   config,
+		logger:   logger,
+		notified: map[*WatchFolder]bool{},
+		pending:  map[string]*pendingFile{},
+		ledger:   ledger,
+		ignored:  map[string]WatchedFile{},
+		stopped:  make(chan struct{}),
+	}, nil
+}
+
+// Watch the folders until the context is done
+func (w *Watcher) RunBlocking() {
+	defer close(w.stopped)
+	if len(w.config.Watch.Folders) == 0 {
+		return
+	}
+
+	var events chan fsnotify.Event
+	var watchErrors chan error
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		w.logger.Error("Failed to create the fsnotify watcher, every folder is polled: ", err)
+	} else {
+		defer fsWatcher.Close()
+		w.fsWatcher = fsWatcher
+		events = fsWatcher.Events
+		watchErrors = fsWatcher.Errors
+	}
+
+	for i := range w.config.Watch.Folders {
+		folder := &w.config.Watch.Folders[i]
+		if !folder.Poll && w.fsWatcher != nil {
+			if err := w.addWatches(folder, folder.Path); err != nil {
+				w.logger.WithField("folder", folder.Path).Warn("Failed to watch the folder, polling it instead: ", err)
+			} else {
+				w.notified[folder] = true
+			}
+		}
+
+		// Catch the files added while interpolarr was stopped
+		w.scanFolder(folder, folder.Path)
+	}
+
+	checkTicker := time.NewTicker(watchCheckInterval)
+	defer checkTicker.Stop()
+	pollTicker := time.NewTicker(time.Duration(w.config.Watch.PollInterval * float64(time.Second)))
+	defer pollTicker.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case event := <-events:
+			w.handleEvent(event)
+		case err := <-watchErrors:
+			// Events can be lost, the folders are scanned again
+			w.logger.Error("Watcher error: ", err)
+			for folder := range w.notified {
+				w.scanFolder(folder, folder.Path)
+			}
+		case <-checkTicker.C:
+			w.checkPending()
+		case <-pollTicker.C:
+			for i := range w.config.Watch.Folders {
+				folder := &w.config.Watch.Folders[i]
+				if !w.notified[folder] {
+					w.scanFolder(folder, folder.Path)
+				}
+			}
+		}
+	}
+}
+
+// Wait for the watcher to stop
+func (w *Watcher) Wait() {
+	<-w.stopped
+}
+
+// Watch the events of dir, and of its subfolders when recursive
+func (w *Watcher) addWatches(folder *WatchFolder, dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.IsDir() {
+			return nil
+		}
+
+		if path != dir && !folder.Recursive {
+			return fs.SkipDir
+		}
+
+		return w.fsWatcher.Add(path)
+	})
+}
+
+// The watched folder of the path with the path relative to it
+func (w *Watcher) folderOf(path string) (*WatchFolder, string) {
+	for i := range w.config.Watch.Folders {
+		folder := &w.config.Watch.Folders[i]
+		relPath, err := filepath.Rel(folder.Path, path)
+		if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		if !folder.Recursive && filepath.Dir(relPath) != "." {
+			continue
+		}
+
+		return folder, relPath
+	}
+
+	return nil, ""
+}
+
+func (w *Watcher) handleEvent(event fsnotify.Event) {
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		delete(w.pending, event.Name)
+		return
+	}
+
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+
+	folder, relPath := w.folderOf(event.Name)
+	if folder == nil {
+		return
+	}
+
+	info, err := os.Stat(event.Name)
+	if err != nil {
+		return
+	}
+
+	if info.IsDir() {
+		if !folder.Recursive || !event.Has(fsnotify.Create) {
+			return
+		}
+
+		// The files of a folder moved in don't have their own events
+		if err := w.addWatches(folder, event.Name); err != nil {
+			w.logger.WithField("folder", event.Name).Error("Failed to watch the folder: ", err)
+		}
+
+		w.scanFolder(folder, event.Name)
+		return
+	}
+
+	w.track(folder, event.Name, relPath, info)
+}
+
+// Track the new files of dir
+func (w *Watcher) scanFolder(folder *WatchFolder, dir string) {
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if path != folder.Path && !folder.Recursive {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		relPath, err := filepath.Rel(folder.Path, path)
+		if err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since it was listed
+			return nil
+		}
+
+		w.track(folder, path, relPath, info)
+		return nil
+	})
+
+	if err != nil {
+		w.logger.WithField("folder", dir).Error("Failed to scan the folder: ", err)
+	}
+}
+
+func (w *Watcher) track(folder *WatchFolder, path string, relPath string, info fs.FileInfo) {
+	if _, ok := w.pending[path]; ok || !info.Mode().IsRegular() || !folder.MatchName(relPath) {
+		return
+	}
+
+	if file, ok := w.ledger[path]; ok && file.Same(info) {
+		return
+	}
+
+	if file, ok := w.ignored[path]; ok && file.Same(info) {
+		return
+	}
+
+	w.logger.WithField("file", path).Debug("New file, waiting for it to be stable")
+	w.pending[path] = &pendingFile{
+		folder:    folder,
+		relPath:   relPath,
+		size:      info.Size(),
+		modTime:   info.ModTime(),
+		changedAt: time.Now(),
+	}
+}
+
+// Add the files that didn't change for the stable time of their folder
+func (w *Watcher) checkPending() {
+	for path, file := range w.pending {
+		info, err := os.Stat(path)
+		if err != nil {
+			delete(w.pending, path)
+			continue
+		}
+
+		if info.Size() != file.size || !info.ModTime().Equal(file.modTime) {
+			file.size = info.Size()
+			file.modTime = info.ModTime()
+			file.changedAt = time.Now()
+			continue
+		}
+
+		if time.Since(file.changedAt).Seconds() < file.folder.StableTime {
+			continue
+		}
+
+		delete(w.pending, path)
+		w.enqueue(path, file, info)
+	}
+}
+
+// The outputs and the processing files of the videos are written in
+// the watched folders when the output template points there
+func isVideoOutput(path string) (bool, error) {
+	videos, err := sqlite.GetAllVideos()
+	if err != nil {
+		return false, err
+	}
+
+	dir := normalizePath(filepath.Dir(path))
+	for _, video := range videos {
+		if video.OutputPath == "" || normalizePath(filepath.Dir(video.OutputPath)) != dir {
+			continue
+		}
+
+		if processingFilePattern(video.OutputPath).MatchString(filepath.Base(path)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (w *Watcher) isInterpolated(path string) bool {
+	ctx, cancel := context.WithTimeout(w.ctx, ruleProbeTimeout)
+	defer cancel()
+
+	videoInfo, _, err := ProbeVideo(ctx, path)
+	if err != nil {
+		// It fails again when processing if it's not a video
+		return false
+	}
+
+	return ReadInterpolarrTags(videoInfo) != nil
+}
+
+func (w *Watcher) enqueue(path string, file *pendingFile, info fs.FileInfo) {
+	logger := w.logger.WithField("file", path)
+	watched := WatchedFile{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime().Unix(),
+	}
+
+	if !file.folder.MatchSize(info.Size()) {
+		logger.Debug("File size is outside of the limits")
+		w.ignored[path] = watched
+		return
+	}
+
+	output, err := isVideoOutput(path)
+	if err != nil {
+		logger.Error("Failed to check the video outputs: ", err)
+		return
+	}
+
+	if output {
+		logger.Debug("File is the output of a video")
+		w.ignored[path] = watched
+		return
+	}
+
+	// Outputs renamed or moved in the folder
+	if *w.config.Skip.SkipInterpolated && w.isInterpolated(path) {
+		logger.Info("File was already interpolated")
+		w.ignored[path] = watched
+		return
+	}
+
+	folder := file.folder
+	template := outputTemplateOrDefault(folder.OutputTemplate, folder.OutRoot)
+	video := Video{
+		Path:     path,
+		Mode:     folder.Mode,
+		Priority: folder.Priority,
+		Settings: folder.Settings,
+		Profile:  folder.Profile,
+		Labels:   folder.Labels,
+	}
+
+	video.OutputPath = templatedOutputPath(&video, template, file.relPath, folder.OutRoot, w.config)
+	video, duplicate, err := EnqueueVideo(video, "")
+
+	if err != nil {
+		logger.Error("Failed to add the file to the queue: ", err)
+		w.ignored[path] = watched
+		return
+	}
+
+	if duplicate {
+		logger.WithField("id", video.ID).Info("File is already queued")
+	} else {
+		logger.WithField("id", video.ID).Info("Added file to the queue")
+	}
+
+	watched.VideoID = video.ID
+	if err := sqlite.UpsertWatchedFile(&watched); err != nil {
+		logger.Error("Failed to save the watched file: ", err)
+	}
+
+	w.ledger[path] = watched
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"math"
 	"os"
@@ -10,6 +11,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Zelak312/interpolarr/rife-ncnn-vulkan-go"
 	"github.com/sirupsen/logrus"
@@ -25,14 +27,32 @@ type Worker struct {
 	sync.RWMutex
 
 	workerInfo WorkerInfo
+	// Videos given by the dispatcher
+	assign chan Video
+
+	// Context of the current video, canceled
+	// to cancel only this video
+	jobCtx    context.Context
+	cancelJob context.CancelFunc
+	// Output being written for the current video
+	outputPath string
+	// The current video was stopped to be resumed later
+	suspended bool
+	// When the current video was started
+	startedAt time.Time
 }
 
 type WorkerInfo struct {
-	ID       int     `json:"id"`
-	Active   bool    `json:"active"`
+	ID     int  `json:"id"`
+	Active bool `json:"active"`
+	Paused bool `json:"paused"`
+	// Exits after the current video
+	Draining bool    `json:"draining"`
 	Step     string  `json:"step"`
 	Progress float64 `json:"progress"`
-	Video    *Video  `json:"video"`
+	// Progress of the whole video when working on a chunk
+	JobProgress float64 `json:"jobProgress"`
+	Video       *Video  `json:"video"`
 }
 
 func NewWorker(id int, logger *logrus.Entry, poolWoker *PoolWorker, hub *Hub) *Worker {
@@ -43,9 +63,14 @@ func NewWorker(id int, logger *logrus.Entry, poolWoker *PoolWorker, hub *Hub) *W
 		logger:     logger,
 		poolWorker: poolWoker,
 		hub:        hub,
+		assign:     make(chan Video, 1),
 	}
 }
 
+func tmpOutputPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".tmp" + filepath.Ext(outputPath)
+}
+
 func ShouldUseTempFile(video *Video, deleteOutputIfAlreadyExist bool) (bool, error) {
 	samePath, err := IsSamePath(video.Path, video.OutputPath)
 	if err != nil {
@@ -69,50 +94,291 @@ func ShouldUseTempFile(video *Video, deleteOutputIfAlreadyExist bool) (bool, err
 }
 
 func (w *Worker) start() {
-	for video := range w.poolWorker.workChannel {
-		w.Lock()
-		w.workerInfo.Active = true
-		w.poolWorker.waitGroup.Add(1)
-		w.workerInfo.Video = &video
-		w.Unlock()
-		err := w.doWork(&video)
-		w.Lock()
-		w.workerInfo.Video = nil
-		w.Unlock()
-		if w.poolWorker.ctx.Err() != nil {
-			w.logger.Debug("Ctx error is: ", w.poolWorker.ctx.Err())
-			if w.poolWorker.ctx.Err() == context.Canceled {
-				w.logger.Debug("Ctx was canceled")
-
-				// End function so call return
-				w.Lock()
-				w.workerInfo.Active = false
-				w.workerInfo.Video = nil
-				w.poolWorker.waitGroup.Done()
-				w.Unlock()
+	for {
+		if w.poolWorker.removeIfDraining(w) {
+			return
+		}
+
+		// Ask the dispatcher for a video
+		select {
+		case <-w.poolWorker.ctx.Done():
+			return
+		case w.poolWorker.workRequests <- w:
+		}
+
+		select {
+		case <-w.poolWorker.ctx.Done():
+			// A video could have been given at the same time
+			select {
+			case video := <-w.assign:
+				w.poolWorker.queue.Requeue(video)
+				w.poolWorker.release(video.ID)
+			default:
+			}
+
+			return
+		case video, ok := <-w.assign:
+			if !ok {
+				// Drained while idle
+				return
+			}
+
+			if !w.runVideo(video) {
 				return
 			}
 		}
+	}
+}
 
-		if err != nil {
-			w.logger.Warn(err)
-			// TODO: make a place where I can store warnings
-			// So I can store warning for each videos
-			// Because the otherwise the issues from runWorker (that doesn't retry)
-			// Won't show anywhere
+// Process the video, returns false when the worker should stop
+func (w *Worker) runVideo(video Video) bool {
+	defer w.poolWorker.release(video.ID)
+	jobCtx, cancelJob := context.WithCancel(w.poolWorker.ctx)
+	w.Lock()
+	w.workerInfo.Active = true
+	w.poolWorker.waitGroup.Add(1)
+	w.workerInfo.Video = &video
+	w.jobCtx = jobCtx
+	w.cancelJob = cancelJob
+	w.startedAt = time.Now()
+	w.Unlock()
+	if video.BatchID != 0 {
+		if err := sqlite.SetBatchStarted(video.BatchID); err != nil {
+			w.logger.Error("Failed to save the batch start: ", err)
 		}
+	}
 
-		w.Lock()
-		w.poolWorker.waitGroup.Done()
-		w.workerInfo.Active = false
+	err := w.doWork(&video)
+	cancelled := jobCtx.Err() != nil && w.poolWorker.ctx.Err() == nil
+	cancelJob()
+	w.Lock()
+	// Labels changed while it ran, kept when it's put back in the queue
+	video.Labels = w.workerInfo.Video.Labels
+	w.workerInfo.Video = nil
+	w.jobCtx = nil
+	w.cancelJob = nil
+	outputPath := w.outputPath
+	w.outputPath = ""
+	suspended := w.suspended
+	w.suspended = false
+	w.Unlock()
+	if w.poolWorker.ctx.Err() != nil {
+		w.logger.Debug("Ctx error is: ", w.poolWorker.ctx.Err())
+		if w.poolWorker.ctx.Err() == context.Canceled {
+			w.logger.Debug("Ctx was canceled")
+			// Shutting down, the video stays queued
+			w.handleSuspendedVideo(&video, outputPath)
+
+			// End function so call return
+			w.Lock()
+			w.workerInfo.Active = false
+			w.workerInfo.Video = nil
+			w.poolWorker.waitGroup.Done()
+			w.Unlock()
+			return false
+		}
+	}
+
+	if cancelled && suspended {
+		w.handleSuspendedVideo(&video, outputPath)
+	} else if cancelled {
+		w.handleCancelledVideo(&video, outputPath)
+	} else if err != nil {
+		w.logger.Warn(err)
+		// TODO: make a place where I can store warnings
+		// So I can store warning for each videos
+		// Because the otherwise the issues from runWorker (that doesn't retry)
+		// Won't show anywhere
+	}
+
+	w.Lock()
+	w.poolWorker.waitGroup.Done()
+	w.workerInfo.Active = false
+	w.Unlock()
+	w.sendUpdate()
+	sendBatchUpdate(video.BatchID, true)
+	return true
+}
+
+// Context of the current video, the pool context when there's none
+func (w *Worker) ctx() context.Context {
+	w.RLock()
+	defer w.RUnlock()
+
+	if w.jobCtx != nil {
+		return w.jobCtx
+	}
+
+	return w.poolWorker.ctx
+}
+
+func (w *Worker) setOutputPath(outputPath string) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.outputPath = outputPath
+}
+
+// Cancel the current video if it's the one with the id,
+// returns true if it was canceled
+func (w *Worker) CancelVideo(id int64) bool {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.workerInfo.Video == nil || w.workerInfo.Video.ID != id || w.cancelJob == nil {
+		return false
+	}
+
+	w.logger.WithField("id", id).Info("Canceling current video")
+	w.cancelJob()
+	return true
+}
+
+// Replace the labels of the video being processed
+func (w *Worker) SetVideoLabels(id int64, labels []string) bool {
+	w.Lock()
+	if w.workerInfo.Video == nil || w.workerInfo.Video.ID != id {
 		w.Unlock()
-		w.sendUpdate()
+		return false
+	}
+
+	// A copy, the job reads the video without the lock
+	video := *w.workerInfo.Video
+	video.Labels = labels
+	w.workerInfo.Video = &video
+	w.Unlock()
+
+	w.sendUpdate()
+	return true
+}
+
+// Take the labels changed while the video was processed
+func (w *Worker) refreshLabels(video *Video) {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.workerInfo.Video != nil && w.workerInfo.Video.ID == video.ID {
+		video.Labels = w.workerInfo.Video.Labels
+	}
+}
+
+// Cancel whatever video is being processed
+func (w *Worker) CancelCurrent() bool {
+	w.RLock()
+	video := w.workerInfo.Video
+	w.RUnlock()
+
+	if video == nil {
+		return false
+	}
+
+	return w.CancelVideo(video.ID)
+}
+
+// Stop the current video and put it back in the queue, it resumes
+// from its last checkpoint. Videos run now are not suspended
+func (w *Worker) SuspendCurrent() bool {
+	w.Lock()
+	defer w.Unlock()
+
+	video := w.workerInfo.Video
+	if video == nil || video.RunNow || w.cancelJob == nil {
+		return false
+	}
+
+	w.logger.WithField("id", video.ID).Info("Suspending current video")
+	w.suspended = true
+	w.cancelJob()
+	return true
+}
+
+func (w *Worker) handleSuspendedVideo(video *Video, outputPath string) {
+	w.logger.WithFields(StructFields(video)).Info("Video was suspended, putting it back in the queue")
+	// The checkpoints are kept, they are written to their own part files
+	if outputPath != "" {
+		samePath, err := IsSamePath(video.Path, outputPath)
+		if err == nil && !samePath {
+			_ = os.Remove(outputPath)
+		}
+	}
+
+	w.recordAttempt(video, AttemptInterrupted, nil, false)
+	if _, ok := w.poolWorker.GetChunkedJob(video.ID); ok && video.Chunk == nil {
+		// It was already split, its chunks are in the queue
+		return
+	}
+
+	w.poolWorker.queue.Requeue(*video)
+}
+
+func (w *Worker) handleCancelledVideo(video *Video, outputPath string) {
+	w.logger.WithFields(StructFields(video)).Info("Video was canceled, cleaning up")
+	if outputPath != "" {
+		samePath, err := IsSamePath(video.Path, outputPath)
+		if err == nil && !samePath {
+			_ = os.Remove(outputPath)
+		}
+	}
+
+	if video.Chunk != nil {
+		if job, ok := w.poolWorker.GetChunkedJob(video.ID); ok {
+			w.poolWorker.RemoveChunkedJob(video.ID)
+			job.RemoveParts()
+		}
+	}
+
+	if err := ClearCheckpoints(video); err != nil {
+		w.logger.Error("Failed to clear checkpoints: ", err)
 	}
+
+	if err := sqlite.CancelVideo(video); err != nil {
+		w.logger.Error("Failed to mark video as canceled: ", err)
+	}
+
+	w.recordAttempt(video, AttemptCancelled, nil, false)
+	w.sendUpdate()
+}
+
+func (w *Worker) IsPaused() bool {
+	w.RLock()
+	defer w.RUnlock()
+
+	return w.workerInfo.Paused
+}
+
+func (w *Worker) IsDraining() bool {
+	w.RLock()
+	defer w.RUnlock()
+
+	return w.workerInfo.Draining
+}
+
+func (w *Worker) SetDraining(draining bool) {
+	w.Lock()
+	w.workerInfo.Draining = draining
+	w.Unlock()
+	w.sendUpdate()
+}
+
+// Stop taking new videos, the current video is still finished
+func (w *Worker) SetPaused(paused bool) {
+	w.Lock()
+	w.workerInfo.Paused = paused
+	w.Unlock()
+	w.sendUpdate()
 }
 
 func (w *Worker) doWork(video *Video) error {
+	if video.Chunk != nil {
+		return w.doChunkWork(video)
+	}
+
+	if video.Mode == JobModeValidate {
+		return w.doValidationWork(video)
+	}
+
 	output, processVideoOutput := w.processVideo(video)
-	if w.poolWorker.ctx.Err() != nil {
+	if w.ctx().Err() != nil {
 		// The context is cancelled, just return
 		// it's handled in start
 		return nil
@@ -124,7 +390,19 @@ func (w *Worker) doWork(video *Video) error {
 		return nil
 	}
 
-	if processVideoOutput.skip && *w.poolWorker.config.CopyFileToDestinationOnSkip {
+	if processVideoOutput.chunked {
+		// The chunks are now in the queue, the video
+		// will be finished by the worker doing the last chunk
+		w.logger.Info("Video was split into chunks")
+		return nil
+	}
+
+	return w.finishVideo(video, output, &processVideoOutput)
+}
+
+func (w *Worker) finishVideo(video *Video, output string, processVideoOutput *ProcessVideoOutput) error {
+	settings := w.settings(video)
+	if processVideoOutput.skip && *settings.CopyFileToDestinationOnSkip {
 		w.logger.WithField("srcPath", video.Path).
 			WithField("destPath", video.OutputPath).
 			Debug("Copying file to destination since it has been skipped")
@@ -147,20 +425,19 @@ func (w *Worker) doWork(video *Video) error {
 		}
 	}
 
-	if processVideoOutput.videoNotFound {
-		w.logger.Error("Video to process wasn't found: ", video.Path)
-		notFoundErr := errors.New("source video not found")
-		w.failVideo(video, output, notFoundErr)
-		return notFoundErr
-	}
-
-	err := sqlite.MarkVideoAsDone(video)
+	err := sqlite.MarkVideoAsSkipped(video, processVideoOutput.skipReason)
 	if err != nil {
 		w.logger.Error("Failed to mark video as done: ", err)
 		return err
 	}
 
-	if *w.poolWorker.config.DeleteInputFileWhenFinished && !processVideoOutput.outputFileAlreadyExist {
+	if processVideoOutput.skip {
+		w.recordAttempt(video, AttemptSkipped, nil, false)
+	} else {
+		w.recordAttempt(video, AttemptDone, nil, false)
+	}
+
+	if *settings.DeleteInputFileWhenFinished && !processVideoOutput.outputFileAlreadyExist {
 		w.logger.Debug("Deleting input file")
 		ok, err := IsSamePath(video.Path, video.OutputPath)
 		if err != nil {
@@ -184,6 +461,110 @@ func (w *Worker) doWork(video *Video) error {
 	return nil
 }
 
+func (w *Worker) doChunkWork(video *Video) error {
+	chunk := video.Chunk
+	job, ok := w.poolWorker.GetChunkedJob(video.ID)
+	if !ok {
+		return fmt.Errorf("no chunked job found for video %d", video.ID)
+	}
+
+	w.logger.WithFields(StructFields(video)).
+		WithField("chunk", chunk.Index).
+		Info("Processing video chunk")
+
+	w.setOutputPath(chunk.Path)
+	progressChan := make(chan float64)
+	go w.updateProgress(progressChan)
+
+	chunkInfo := job.ChunkInfo(chunk)
+	w.updateStep(fmt.Sprintf("Interpolating chunk %d/%d", chunk.Index+1, chunk.Count))
+	settings := w.settings(video)
+	err := w.interpolate(&Interpolation{
+		VideoInfo:     &chunkInfo,
+		Segment:       &VideoSegment{Start: chunk.Start, Duration: chunk.Duration},
+		OutputPath:    chunk.Path,
+		TargetFPS:     settings.TargetFPS,
+		ModelPath:     settings.ModelPath,
+		Rife:          settings.Rife,
+		FFmpegOptions: settings.FFmpegOptions,
+	}, progressChan)
+	close(progressChan)
+	if w.ctx().Err() != nil {
+		return nil
+	}
+
+	if err != nil {
+		w.logger.WithFields(StructFields(video)).
+			WithField("chunk", chunk.Index).
+			Error("Error processing video chunk: ", err)
+		_ = os.Remove(chunk.Path)
+
+		retry := w.poolWorker.config.Retry
+		permanent := IsPermanentError(err, "")
+		if permanent || chunk.Retries+1 >= retry.MaxAttempts {
+			w.recordAttempt(video, AttemptFailed, err, permanent)
+			// No point in doing the other chunks
+			w.poolWorker.RemoveChunkedJob(video.ID)
+			w.poolWorker.queue.RemoveAllByID(video.ID)
+			job.RemoveParts()
+			_ = w.failVideo(&job.video, "", fmt.Errorf("chunk %d failed: %v", chunk.Index, err))
+			return nil
+		}
+
+		// Only this chunk is retried
+		notBefore := time.Now().Add(retry.Delay(chunk.Retries + 1))
+		video.NotBefore = &notBefore
+		w.recordAttempt(video, AttemptRetry, err, false)
+		chunk.Retries++
+		w.refreshLabels(video)
+		w.poolWorker.queue.Enqueue(*video)
+		w.logger.WithField("chunk", chunk.Index).
+			WithField("notBefore", notBefore).
+			Info("Requeue video chunk (back of the queue and retrying)")
+		return nil
+	}
+
+	if !job.MarkChunkDone(chunk) {
+		return nil
+	}
+
+	return w.finishChunkedJob(job)
+}
+
+// Concat every chunk of the job and finish the parent video
+func (w *Worker) finishChunkedJob(job *ChunkedJob) error {
+	video := job.video
+	w.logger.WithFields(StructFields(video)).Info("Every chunk is done, concatenating them")
+	w.updateStep("Concatenating chunks")
+	settings := w.settings(&video)
+	tags := NewInterpolarrTags(&video, &settings, &job.videoInfo)
+	output, err := ConcatVideoParts(w.ctx(), job.PartPaths(), video.Path, job.outputPath, tags)
+	if w.ctx().Err() != nil {
+		// The parts are kept when the video is suspended
+		return nil
+	}
+
+	defer job.RemoveParts()
+	defer w.poolWorker.RemoveChunkedJob(video.ID)
+
+	if err != nil {
+		w.handleProcessVideoError(&video, output, &ProcessVideoOutput{err: err})
+		return nil
+	}
+
+	// Rendered while the source is still there
+	w.renderJobComparison(&video, job.outputPath)
+	if job.useTmpFile {
+		w.logger.Debug("Moving tmp file to output path since everything was succesful")
+		err := RenameOverwrite(job.outputPath, video.OutputPath)
+		if err != nil {
+			w.logger.Errorf("Error when renaming overwrite: %v", err)
+		}
+	}
+
+	return w.finishVideo(&video, "", &ProcessVideoOutput{})
+}
+
 func (w *Worker) handleProcessVideoError(video *Video, output string, processVideoOutput *ProcessVideoOutput) {
 	w.logger.WithFields(StructFields(video)).Error("Error processing video: ", processVideoOutput.err)
 	if output != "" {
@@ -196,11 +577,21 @@ func (w *Worker) handleProcessVideoError(video *Video, output string, processVid
 		return
 	}
 
-	if retries >= retryLimit {
+	retry := w.poolWorker.config.Retry
+	permanent := IsPermanentError(processVideoOutput.err, output)
+	if permanent || retries+1 >= retry.MaxAttempts {
+		if permanent {
+			w.logger.WithFields(StructFields(video)).Info("Error is permanent, not retrying")
+		}
+
+		w.recordAttempt(video, AttemptFailed, processVideoOutput.err, permanent)
 		_ = w.failVideo(video, output, processVideoOutput.err)
 		return
 	}
 
+	notBefore := time.Now().Add(retry.Delay(retries + 1))
+	video.NotBefore = &notBefore
+	w.recordAttempt(video, AttemptRetry, processVideoOutput.err, false)
 	retries++
 	err = sqlite.UpdateVideoRetries(video, retries)
 	if err != nil {
@@ -208,8 +599,16 @@ func (w *Worker) handleProcessVideoError(video *Video, output string, processVid
 		return
 	}
 
+	err = sqlite.MoveVideoToBack(video)
+	if err != nil {
+		w.logger.WithFields(StructFields(video)).Error("Failed to move video to the back of the queue: ", err)
+	}
+
+	w.refreshLabels(video)
 	w.poolWorker.queue.Enqueue(*video)
-	w.logger.WithFields(StructFields(video)).Info("Requeue video (back of the queue and retrying)")
+	w.logger.WithFields(StructFields(video)).
+		WithField("notBefore", notBefore).
+		Info("Requeue video (back of the queue and retrying)")
 }
 
 func (w *Worker) failVideo(video *Video, output string, failError error) error {
@@ -220,6 +619,10 @@ func (w *Worker) failVideo(video *Video, output string, failError error) error {
 		return err
 	}
 
+	if err := ClearCheckpoints(video); err != nil {
+		w.logger.WithFields(StructFields(video)).Error("Failed to clear checkpoints: ", err)
+	}
+
 	return nil
 }
 
@@ -228,11 +631,13 @@ func (w *Worker) processVideo(video *Video) (string, ProcessVideoOutput) {
 
 	videoExist, err := PathExist(video.Path)
 	if err != nil {
-		return "", ProcessVideoOutput{}
+		return "", ProcessVideoOutput{err: err}
 	}
 
 	if !videoExist {
-		return "", ProcessVideoOutput{videoNotFound: true}
+		// Retried, the source can show up later
+		w.logger.Warn("Video to process wasn't found: ", video.Path)
+		return "", ProcessVideoOutput{err: errors.New("source video not found")}
 	}
 
 	baseOutputPath := path.Dir(video.OutputPath)
@@ -259,89 +664,273 @@ func (w *Worker) processVideo(video *Video) (string, ProcessVideoOutput) {
 
 	if useTmpFile {
 		w.logger.Debug("Using tmp file")
-		outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".tmp" + filepath.Ext(outputPath)
+		outputPath = tmpOutputPath(outputPath)
 
 		log.Debugf("checking tmp path: %s", outputPath)
 		videoTmpExist, err := PathExist(outputPath)
 		if err != nil {
-			return "", ProcessVideoOutput{}
+			return "", ProcessVideoOutput{err: err}
 		}
 
 		if videoTmpExist {
-			log.Warn("Tmp video file output already exist, skipping")
-			return "", ProcessVideoOutput{skip: true}
+			// Left by a run that didn't finish, the video is running here
+			quarantined, err := removeStaleFile(w.poolWorker.config, video, outputPath)
+			if err != nil {
+				return "", ProcessVideoOutput{err: err}
+			}
+
+			w.logger.WithField("file", outputPath).
+				WithField("quarantined", quarantined).
+				Warn("Tmp video file output already exist, removed it")
 		}
 	}
 
+	w.setOutputPath(outputPath)
 	progressChan := make(chan float64)
 	defer close(progressChan)
 	go w.updateProgress(progressChan)
 
 	w.logger.Info("Getting video information")
 	w.updateStep("Getting video information")
-	videoInfo, output, err := GetVideoInfo(w.poolWorker.ctx, video.Path)
+	videoInfo, output, err := GetVideoInfo(w.ctx(), video.Path)
 	if err != nil {
 		return output, ProcessVideoOutput{err: err}
 	}
 
+	settings := w.settings(video)
 	w.logger.Info("fps: ", videoInfo.FrameRate)
-	w.logger.Info("target fps: ", w.poolWorker.config.TargetFPS)
+	w.logger.Info("target fps: ", settings.TargetFPS)
 	w.logger.Info("framecount: ", videoInfo.FrameCount)
 
-	if videoInfo.FrameRate >= w.poolWorker.config.TargetFPS {
-		w.logger.Info(`Video is already higher or equal to target FPS, skipping`)
-		return "", ProcessVideoOutput{skip: true}
+	if reason := w.poolWorker.config.Skip.Reason(videoInfo, settings.TargetFPS); reason != "" {
+		w.logger.WithField("reason", reason).Info("Skipping video")
+		return "", ProcessVideoOutput{skip: true, skipReason: reason}
+	}
+
+	w.updateStep("Looking for a duplicate")
+	if processVideoOutput := w.reuseDuplicate(video, outputPath, useTmpFile, &settings); processVideoOutput != nil {
+		return "", *processVideoOutput
+	}
+
+	if w.poolWorker.ShouldChunk(videoInfo) {
+		job, output, err := w.splitVideo(video, videoInfo, outputPath, useTmpFile)
+		if err != nil {
+			return output, ProcessVideoOutput{err: err}
+		}
+
+		if job != nil {
+			return "", ProcessVideoOutput{chunked: true}
+		}
 	}
 
-	targetFrameCount := int64(float64(videoInfo.FrameCount) / videoInfo.FrameRate * w.poolWorker.config.TargetFPS)
+	if *w.poolWorker.config.Checkpoint.Enabled {
+		output, err := w.interpolateWithCheckpoints(video, videoInfo, outputPath, progressChan)
+		if err != nil {
+			return output, ProcessVideoOutput{err: err}
+		}
+	} else {
+		err = w.interpolate(&Interpolation{
+			VideoInfo:     videoInfo,
+			OutputPath:    outputPath,
+			TargetFPS:     settings.TargetFPS,
+			ModelPath:     settings.ModelPath,
+			Rife:          settings.Rife,
+			FFmpegOptions: settings.FFmpegOptions,
+			Tags:          NewInterpolarrTags(video, &settings, videoInfo),
+		}, progressChan)
+		if err != nil {
+			return "", ProcessVideoOutput{err: err}
+		}
+	}
+
+	// Rendered while the source is still there
+	w.renderJobComparison(video, outputPath)
+	if useTmpFile {
+		w.logger.Debug("Moving tmp file to output path since everything was succesful")
+		err := RenameOverwrite(outputPath, video.OutputPath)
+		if err != nil {
+			w.logger.Errorf("Error when renaming overwrite: %v", err)
+		}
+	}
+
+	return "", ProcessVideoOutput{}
+}
+
+// Split the video into chunks and send them to the queue, returns
+// a nil job when the video couldn't be split
+func (w *Worker) splitVideo(video *Video, videoInfo *VideoInfo,
+	outputPath string, useTmpFile bool) (*ChunkedJob, string, error) {
+	w.logger.Info("Finding keyframes to split the video")
+	w.updateStep("Finding keyframes")
+	keyframes, output, err := GetKeyframeTimes(w.ctx(), video.Path)
+	if err != nil {
+		return nil, output, err
+	}
+
+	segments := planChunks(keyframes, videoInfo.Duration, w.poolWorker.config.Chunking.Chunks)
+	if len(segments) < 2 {
+		w.logger.Info("Not enough keyframes to split the video, processing it whole")
+		return nil, "", nil
+	}
+
+	w.logger.Info("Splitting video in chunks: ", len(segments))
+	w.refreshLabels(video)
+	job := NewChunkedJob(*video, *videoInfo, outputPath, useTmpFile, segments)
+	w.poolWorker.AddChunkedJob(job)
+	w.poolWorker.queue.EnqueueFront(job.Videos())
+	return job, "", nil
+}
+
+// Everything needed to run the interpolation of a video
+type Interpolation struct {
+	VideoInfo *VideoInfo
+	// Only interpolate this part of the video when set
+	Segment *VideoSegment
+	// Keep the audio of the segment, the chunks get theirs when concatenated
+	SegmentAudio bool
+	OutputPath   string
+	// Output frame to start from, used to resume
+	StartFrame int64
+	// When set, the output is written in parts of PartFrames frames
+	// instead of the output path, OnPartDone is called once the part
+	// is fully flushed
+	PartFrames int64
+	PartPath   func(index int) string
+	OnPartDone func(path string, lastFrame int64) error
+	// Use the config values when not set
+	TargetFPS     float64
+	ModelPath     string
+	Rife          *RifeOptions
+	FFmpegOptions *FFmpegOptions
+	// Written in the output when set
+	Tags *InterpolarrTags
+}
+
+func (w *Worker) interpolate(interpolation *Interpolation, progressChan chan<- float64) error {
+	videoInfo := interpolation.VideoInfo
+	targetFPS := interpolation.TargetFPS
+	if targetFPS == 0 {
+		targetFPS = w.poolWorker.config.TargetFPS
+	}
+
+	modelPath := interpolation.ModelPath
+	if modelPath == "" {
+		modelPath = w.poolWorker.config.ModelPath
+	}
+
+	ffmpegOptions := w.poolWorker.config.FFmpegOptions
+	if interpolation.FFmpegOptions != nil {
+		ffmpegOptions = *interpolation.FFmpegOptions
+	}
+
+	targetFrameCount := int64(float64(videoInfo.FrameCount) / videoInfo.FrameRate * targetFPS)
 	scale := float64(videoInfo.FrameCount) / float64(targetFrameCount)
 	w.logger.Info("Calculated frame target: ", targetFrameCount)
 	w.logger.Info("Calculated scale: ", scale)
 
 	// Setup rife
 	w.logger.Info("Setup rife")
-	config := rife.DefaultConfig(videoInfo.Width, videoInfo.Height)
-	r, err := rife.New(config)
+	r, err := newRife(videoInfo, modelPath, interpolation.Rife)
 	if err != nil {
-		return "", ProcessVideoOutput{err: err}
+		return err
 	}
 
 	defer r.Close()
-	err = r.LoadModel(w.poolWorker.config.ModelPath)
-	if err != nil {
-		return "", ProcessVideoOutput{err: err}
+
+	// When resuming, seek to the source frame needed
+	// for the first output frame
+	startIdx := int64(math.Floor(float64(interpolation.StartFrame) * scale))
+	if startIdx > videoInfo.FrameCount-2 {
+		startIdx = max(videoInfo.FrameCount-2, 0)
+	}
+
+	segment := interpolation.Segment
+	if startIdx > 0 {
+		w.logger.Info("Resuming from frame: ", interpolation.StartFrame)
+		offset := float64(startIdx) / videoInfo.FrameRate
+		resumeSegment := VideoSegment{Start: offset}
+		if segment != nil {
+			resumeSegment.Start += segment.Start
+			resumeSegment.Duration = segment.Duration - offset
+		}
+
+		segment = &resumeSegment
 	}
 
 	// Setup ffmpeg processor
 	w.logger.Info("Setup ffmpeg processor")
-	vp, err := NewVideoProcessor(videoInfo, w.poolWorker.config.FFmpegOptions)
+	vp, err := NewVideoProcessor(videoInfo, ffmpegOptions)
 	if err != nil {
-		return "", ProcessVideoOutput{err: err}
+		return err
 	}
 
-	if err := vp.StartReading(w.poolWorker.ctx); err != nil {
-		return "", ProcessVideoOutput{err: err}
+	vp.SetSegment(segment)
+	vp.SetTags(interpolation.Tags)
+	if err := vp.StartReading(w.ctx()); err != nil {
+		return err
 	}
 
-	if err := vp.StartWriting(w.poolWorker.ctx, outputPath, w.poolWorker.config.TargetFPS); err != nil {
-		return "", ProcessVideoOutput{err: err}
-	}
+	partIndex := 0
+	partPath := ""
+	// Only the finished parts can be resumed, the part being written
+	// is removed once the processor is closed when stopping early
+	defer func() {
+		if partPath != "" {
+			_ = os.Remove(partPath)
+		}
+	}()
 
 	defer vp.Close()
+	startWriting := func() error {
+		if interpolation.PartFrames == 0 {
+			if interpolation.Segment != nil && !interpolation.SegmentAudio {
+				return vp.StartWritingVideoOnly(w.ctx(), interpolation.OutputPath, targetFPS)
+			}
+
+			return vp.StartWriting(w.ctx(), interpolation.OutputPath, targetFPS)
+		}
+
+		partPath = interpolation.PartPath(partIndex)
+		partIndex++
+		// Could be a leftover from an interrupted part
+		_ = os.Remove(partPath)
+		return vp.StartWritingVideoOnly(w.ctx(), partPath, targetFPS)
+	}
+
+	finishPart := func(lastFrame int64) error {
+		if err := vp.CloseWriting(); err != nil {
+			return err
+		}
+
+		if err := interpolation.OnPartDone(partPath, lastFrame); err != nil {
+			return err
+		}
+
+		partPath = ""
+		return nil
+	}
+
+	if err := startWriting(); err != nil {
+		return err
+	}
+
 	frame1, err := vp.ReadFrame()
 	if err != nil {
-		return "", ProcessVideoOutput{err: err}
+		return err
 	}
 
 	frame2, err := vp.ReadFrame()
 	if err != nil {
-		return "", ProcessVideoOutput{err: err}
+		return err
 	}
 
 	w.logger.Info("Start inpterpolation loop")
-	w.updateStep("Interpolating frames")
-	currentIdx := int64(0)
-	for i := int64(0); i < targetFrameCount; i++ {
+	if interpolation.Segment == nil {
+		w.updateStep("Interpolating frames")
+	}
+
+	currentIdx := startIdx
+	for i := interpolation.StartFrame; i < targetFrameCount; i++ {
 		// Calculate frame position and timestep
 		fx := float64(i) * scale
 		sx := int64(math.Floor(fx))
@@ -368,7 +957,7 @@ func (w *Worker) processVideo(video *Video) (string, ProcessVideoOutput) {
 					break
 				}
 
-				return "", ProcessVideoOutput{err: err}
+				return err
 			}
 			currentIdx++
 		}
@@ -377,13 +966,13 @@ func (w *Worker) processVideo(video *Video) (string, ProcessVideoOutput) {
 		if timestep == 0 {
 			// Direct frame
 			if err := vp.WriteFrame(frame1); err != nil {
-				return "", ProcessVideoOutput{err: err}
+				return err
 			}
 		} else {
 			// Interpolated frame
 			interpolated, err := r.InterpolateBGR(frame1.Data, frame2.Data, timestep)
 			if err != nil {
-				return "", ProcessVideoOutput{err: err}
+				return err
 			}
 
 			if err := vp.WriteFrame(Frame{
@@ -391,22 +980,45 @@ func (w *Worker) processVideo(video *Video) (string, ProcessVideoOutput) {
 				Width:  vp.Width(),
 				Height: vp.Height(),
 			}); err != nil {
-				return "", ProcessVideoOutput{err: err}
+				return err
 			}
 		}
 
 		progressChan <- float64(i) / float64(targetFrameCount) * 100
-	}
 
-	if useTmpFile {
-		w.logger.Debug("Moving tmp file to output path since everything was succesful")
-		err := RenameOverwrite(outputPath, video.OutputPath)
-		if err != nil {
-			w.logger.Errorf("Error when renaming overwrite: %v", err)
+		written := i + 1 - interpolation.StartFrame
+		if interpolation.PartFrames > 0 && written%interpolation.PartFrames == 0 && i+1 < targetFrameCount {
+			if err := finishPart(i); err != nil {
+				return err
+			}
+
+			if err := startWriting(); err != nil {
+				return err
+			}
 		}
 	}
 
-	return "", ProcessVideoOutput{}
+	if interpolation.PartFrames > 0 {
+		return finishPart(targetFrameCount - 1)
+	}
+
+	return nil
+}
+
+func newRife(videoInfo *VideoInfo, modelPath string, options *RifeOptions) (*rife.Rife, error) {
+	r, err := rife.New(options.config(videoInfo.Width, videoInfo.Height))
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.LoadModel(modelPath)
+	if err != nil {
+		r.Close()
+		// Retrying won't find the model
+		return nil, &PermanentError{err}
+	}
+
+	return r, nil
 }
 
 func (w *Worker) updateStep(step string) {
@@ -422,13 +1034,28 @@ func (w *Worker) updateProgress(progressChan <-chan float64) {
 	for progress := range progressChan {
 		w.Lock()
 		w.workerInfo.Progress = progress
+		w.workerInfo.JobProgress = progress
+		batchID := int64(0)
+		if video := w.workerInfo.Video; video != nil {
+			batchID = video.BatchID
+			if video.Chunk != nil {
+				if job, ok := w.poolWorker.GetChunkedJob(video.ID); ok {
+					w.workerInfo.JobProgress = job.SetProgress(video.Chunk, progress)
+				}
+			}
+		}
 
 		w.Unlock()
 		w.sendUpdate()
+		sendBatchUpdate(batchID, false)
 	}
 }
 
 func (w *Worker) sendUpdate() {
+	if w.hub == nil {
+		return
+	}
+
 	w.Lock()
 	defer w.Unlock()
 
@@ -9,7 +9,22 @@ type WsWorkerProgress struct {
 	WorkerInfo
 }
 
+type WsWorkersUpdate struct {
+	WsBaseMessage
+	Workers []WorkerInfo `json:"workers"`
+}
+
 type WsQeueuUpdate struct {
 	WsBaseMessage
 	Videos []Video `json:"videos"`
 }
+
+type WsBatchUpdate struct {
+	WsBaseMessage
+	BatchInfo
+}
+
+type WsDispatcherUpdate struct {
+	WsBaseMessage
+	DispatcherInfo
+}
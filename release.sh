@@ -9,7 +9,7 @@ fi
 echo "Preparing $1..."
 # update the version
 msg="# managed by release.sh"
-# sed -E -i "s/^version = \".*\"/version = \"${1#v}\"/" ./Cargo.toml
+sed -E -i "s/^const Version = \".*\"/const Version = \"${1#v}\"/" ./interpolarr/version.go
 # update the changelog
 git-cliff --config cliff.toml --tag "$1" > CHANGELOG.md
 git add -A && git commit -m "chore(release): prepare for $1"